@@ -9,19 +9,26 @@ services = {}
 services["node_builder"] = {
     "build": ".",
     "image": IMAGE_NAME,
-    "command": [str(BASE_PORT)],
+    "command": ["start_node", str(BASE_PORT)],
     "ports": [f"{BASE_PORT}:{BASE_PORT}/udp"],
     "deploy": {"replicas": 0}  # Prevents running this builder service
 }
 
+SEED = f"node_0:{BASE_PORT}"  # every other node bootstraps through the first one
+
 for i in range(NUM_NODES):
     port = BASE_PORT + i
     name = f"node_{i}"
+    command = ["start_node", str(port), "--host", name]
+    if i > 0:
+        command += ["--seed", SEED]
     services[name] = {
         "image": IMAGE_NAME,
-        "command": [str(port)],
+        "command": command,
         "ports": [f"{port}:{port}/udp"]
     }
+    if i > 0:
+        services[name]["depends_on"] = ["node_0"]
 
 compose = {
     "version": "3.8",
@@ -4,21 +4,52 @@ import (
 	"fmt"
 	"os"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 const TimeLayout = "2006-01-02 15:04:05"
 
 var Verbose bool
+var LogLevel string
+var LogFormat string
 
 func init() {
-	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output (same as --log-level debug)")
+	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "info", "log level (trace, debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "log format (text or json)")
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "helloworld",
 	Short: "helloworld",
 	Long:  "helloworld",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogging()
+	},
+}
+
+// setupLogging configures the global logrus logger from the command line flags
+func setupLogging() error {
+	level, err := log.ParseLevel(LogLevel)
+	if err != nil {
+		return err
+	}
+	if Verbose && level < log.DebugLevel {
+		level = log.DebugLevel
+	}
+	log.SetLevel(level)
+
+	switch LogFormat {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", LogFormat)
+	}
+
+	return nil
 }
 
 func Execute() {
@@ -1,20 +1,51 @@
 package cli
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/ncyborgse/go-template/pkg/gossip"
 	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var DataDir string
+var Host string
+var Seeds []string
+var WantPeers int
+var BootstrapTimeout time.Duration
+var TTL int
+var Fanout int
+var BatchSize int
+var MaxPeers int
+var AntiEntropy bool
+
 func init() {
+	StartNodeCmd.Flags().StringVar(&DataDir, "data-dir", ".", "directory for persistent node state")
+	StartNodeCmd.Flags().StringVar(&Host, "host", "localhost", "host name other nodes use to reach this node")
+	StartNodeCmd.Flags().StringSliceVar(&Seeds, "seed", nil, "seed node address (host:port), can be repeated")
+	StartNodeCmd.Flags().IntVar(&WantPeers, "peers", 8, "number of peers to discover before bootstrap is complete")
+	StartNodeCmd.Flags().DurationVar(&BootstrapTimeout, "bootstrap-timeout", 30*time.Second, "how long to look for peers")
+	StartNodeCmd.Flags().IntVar(&TTL, "ttl", gossip.DefaultTTL, "hop count of the rumors this node creates")
+	StartNodeCmd.Flags().IntVar(&Fanout, "fanout", 0, "peers per push, 0 pushes to all peers")
+	StartNodeCmd.Flags().IntVar(&BatchSize, "batch", 1, "rumors coalesced per peer and packet, 1 sends each on its own")
+	StartNodeCmd.Flags().IntVar(&MaxPeers, "max-peers", gossip.DefaultMaxPeers, "peer list limit, 0 means no limit")
+	StartNodeCmd.Flags().BoolVar(&AntiEntropy, "anti-entropy", true, "exchange digests with peers to repair missed rumors")
 	rootCmd.AddCommand(StartNodeCmd)
 }
 
 var StartNodeCmd = &cobra.Command{
-	Use:   "start_node",
+	Use:   "start_node <port>",
 	Short: "Start a new node",
 	Long:  "Start a new node in the gossip network",
+	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		net := network.NewUDPNetwork()
 		port := args[0]
@@ -24,9 +55,63 @@ var StartNodeCmd = &cobra.Command{
 			return
 		}
 		addr := network.Address{
-			IP:   "localhost",
+			IP:   Host,
 			Port: iport,
 		}
-		net.Listen(addr)
+
+		seeds := make([]network.Address, 0, len(Seeds))
+		for _, seed := range Seeds {
+			seedaddr, err := network.ParseAddress(seed)
+			if err != nil {
+				cmd.Println(err)
+				return
+			}
+			seeds = append(seeds, seedaddr)
+		}
+
+		// keep the same identity across restarts
+		id, err := node.LoadOrCreateNodeID(filepath.Join(DataDir, "node.id"))
+		if err != nil {
+			cmd.Println(err)
+			return
+		}
+
+		n, err := node.NewNodeWithID(net, addr, id)
+		if err != nil {
+			cmd.Println(err)
+			return
+		}
+		config := gossip.DefaultGossipConfig()
+		config.TTL = TTL
+		config.Fanout = Fanout
+		config.BatchSize = BatchSize
+		config.MaxPeers = MaxPeers
+		config.AntiEntropy = AntiEntropy
+		config.DataDir = DataDir
+		gn, err := gossip.NewGossipNodeFromNode(n, 0, config, nil)
+		if err != nil {
+			cmd.Println(err)
+			return
+		}
+		gn.Start()
+		log.WithFields(log.Fields{"NodeID": id.String(), "Addr": addr.String()}).Info("Node started")
+
+		// a node without seeds is the first node of the cluster
+		if len(seeds) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), BootstrapTimeout)
+			err := gn.Bootstrap(ctx, seeds, WantPeers)
+			cancel()
+			if err != nil {
+				// keep running, peers that join later will find us
+				log.WithError(err).Warn("Bootstrap incomplete")
+			}
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+
+		log.Info("Shutting down")
+		gn.Close()
 	},
 }
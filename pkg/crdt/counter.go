@@ -0,0 +1,145 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// GCounter is a grow-only counter. Each replica counts its own increments
+// and the value is the sum over all replicas.
+type GCounter struct {
+	listeners
+	replica string
+
+	mu     sync.RWMutex
+	counts map[string]uint64
+}
+
+// NewGCounter creates a grow-only counter updated locally as replica
+func NewGCounter(replica string) *GCounter {
+	return &GCounter{replica: replica, counts: make(map[string]uint64)}
+}
+
+// Increment adds delta to the counter
+func (c *GCounter) Increment(delta uint64) {
+	c.mu.Lock()
+	c.counts[c.replica] += delta
+	c.mu.Unlock()
+
+	c.changed()
+}
+
+// Value returns the sum of all increments
+func (c *GCounter) Value() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var total uint64
+	for _, count := range c.counts {
+		total += count
+	}
+	return total
+}
+
+// Merge takes the highest count seen for every replica
+func (c *GCounter) Merge(other *GCounter) {
+	other.mu.RLock()
+	counts := make(map[string]uint64, len(other.counts))
+	for replica, count := range other.counts {
+		counts[replica] = count
+	}
+	other.mu.RUnlock()
+
+	c.merge(counts)
+}
+
+func (c *GCounter) merge(counts map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for replica, count := range counts {
+		if count > c.counts[replica] {
+			c.counts[replica] = count
+		}
+	}
+}
+
+func (c *GCounter) MarshalJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.Marshal(c.counts)
+}
+
+func (c *GCounter) MergeJSON(data []byte) error {
+	var counts map[string]uint64
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return fmt.Errorf("failed to unmarshal g-counter: %v", err)
+	}
+	c.merge(counts)
+	return nil
+}
+
+// PNCounter is a counter that can also be decremented. It keeps one
+// grow-only counter for increments and one for decrements.
+type PNCounter struct {
+	listeners
+	p *GCounter
+	n *GCounter
+}
+
+type pnState struct {
+	P json.RawMessage `json:"p"`
+	N json.RawMessage `json:"n"`
+}
+
+// NewPNCounter creates a counter updated locally as replica
+func NewPNCounter(replica string) *PNCounter {
+	return &PNCounter{p: NewGCounter(replica), n: NewGCounter(replica)}
+}
+
+// Increment adds delta to the counter
+func (c *PNCounter) Increment(delta uint64) {
+	c.p.Increment(delta)
+	c.changed()
+}
+
+// Decrement subtracts delta from the counter
+func (c *PNCounter) Decrement(delta uint64) {
+	c.n.Increment(delta)
+	c.changed()
+}
+
+// Value returns the increments minus the decrements
+func (c *PNCounter) Value() int64 {
+	return int64(c.p.Value()) - int64(c.n.Value())
+}
+
+// Merge merges both halves of other into the counter
+func (c *PNCounter) Merge(other *PNCounter) {
+	c.p.Merge(other.p)
+	c.n.Merge(other.n)
+}
+
+func (c *PNCounter) MarshalJSON() ([]byte, error) {
+	p, err := c.p.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	n, err := c.n.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(pnState{P: p, N: n})
+}
+
+func (c *PNCounter) MergeJSON(data []byte) error {
+	var state pnState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to unmarshal pn-counter: %v", err)
+	}
+	if err := c.p.MergeJSON(state.P); err != nil {
+		return err
+	}
+	return c.n.MergeJSON(state.N)
+}
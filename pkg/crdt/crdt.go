@@ -0,0 +1,68 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// CRDT is a state-based conflict-free replicated data type. Merging is
+// commutative, associative and idempotent, so replicas that have merged the
+// same states hold the same value no matter the order or repetition.
+type CRDT interface {
+	json.Marshaler
+
+	// MergeJSON merges a state produced by MarshalJSON on another replica
+	MergeJSON(data []byte) error
+
+	// OnChange registers a function that is called after every local update
+	OnChange(fn func())
+}
+
+// timestamp orders writes for last-writer-wins types. Ties on Time are
+// broken by the replica id, so every replica picks the same winner.
+type timestamp struct {
+	Time    int64  `json:"time"`
+	Replica string `json:"replica"`
+}
+
+// after reports whether t wins over other
+func (t timestamp) after(other timestamp) bool {
+	if t.Time != other.Time {
+		return t.Time > other.Time
+	}
+	return t.Replica > other.Replica
+}
+
+// next returns a timestamp for a new local write that wins over last, even
+// if the local clock is behind the replica that wrote last
+func next(replica string, last timestamp) timestamp {
+	now := time.Now().UnixNano()
+	if now <= last.Time {
+		now = last.Time + 1
+	}
+	return timestamp{Time: now, Replica: replica}
+}
+
+// listeners keeps the change callbacks of a CRDT
+type listeners struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+func (l *listeners) OnChange(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fns = append(l.fns, fn)
+}
+
+// changed runs the callbacks. It must be called without the CRDT's lock held.
+func (l *listeners) changed() {
+	l.mu.Lock()
+	fns := l.fns
+	l.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
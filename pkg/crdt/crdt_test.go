@@ -0,0 +1,105 @@
+package crdt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	a, b := NewGCounter("a"), NewGCounter("b")
+	a.Increment(3)
+	b.Increment(2)
+	b.Increment(1)
+
+	a.Merge(b)
+	b.Merge(a)
+	a.Merge(b) // merging again changes nothing
+	if a.Value() != 6 || b.Value() != 6 {
+		t.Errorf("expected both g-counters to be 6, got %d and %d", a.Value(), b.Value())
+	}
+
+	x, y := NewPNCounter("x"), NewPNCounter("y")
+	x.Increment(5)
+	y.Decrement(7)
+	x.Decrement(1)
+
+	data, err := y.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := x.MergeJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	y.Merge(x)
+	if x.Value() != -3 || y.Value() != -3 {
+		t.Errorf("expected both pn-counters to be -3, got %d and %d", x.Value(), y.Value())
+	}
+}
+
+func TestLWWRegister(t *testing.T) {
+	a, b := NewLWWRegister("a"), NewLWWRegister("b")
+	if _, set := a.Get(); set {
+		t.Errorf("expected a new register to be unset")
+	}
+
+	a.Set("first")
+	b.Merge(a)
+	b.Set("second") // written after seeing "first", so it wins
+
+	a.Merge(b)
+	b.Merge(a)
+	for _, r := range []*LWWRegister{a, b} {
+		if value, _ := r.Get(); value != "second" {
+			t.Errorf("expected second, got %q", value)
+		}
+	}
+}
+
+func TestORSetAddWins(t *testing.T) {
+	a, b := NewORSet("a"), NewORSet("b")
+	a.Add("x")
+	a.Add("y")
+	b.Merge(a)
+
+	// b removes x while a concurrently adds it again
+	b.Remove("x")
+	a.Add("x")
+	a.Remove("y")
+
+	a.Merge(b)
+	b.Merge(a)
+	for _, s := range []*ORSet{a, b} {
+		if got := s.Elements(); !reflect.DeepEqual(got, []string{"x"}) {
+			t.Errorf("expected [x], got %v", got)
+		}
+	}
+}
+
+func TestLWWMap(t *testing.T) {
+	a, b := NewLWWMap("a"), NewLWWMap("b")
+	a.Set("flag.beta", "on")
+	a.Set("flag.dark", "off")
+	b.Merge(a)
+
+	b.Delete("flag.dark")
+	b.Set("flag.beta", "off")
+	a.Set("flag.new", "on")
+
+	data, err := b.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.MergeJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	b.Merge(a)
+
+	for _, m := range []*LWWMap{a, b} {
+		if keys := m.Keys(); !reflect.DeepEqual(keys, []string{"flag.beta", "flag.new"}) {
+			t.Errorf("expected flag.beta and flag.new, got %v", keys)
+		}
+		if value, _ := m.Get("flag.beta"); value != "off" {
+			t.Errorf("expected flag.beta to be off, got %q", value)
+		}
+	}
+}
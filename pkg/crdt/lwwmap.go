@@ -0,0 +1,113 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// LWWMap is a map of strings where every key behaves like an LWWRegister.
+// Deletes are kept as tombstones so they win over older writes.
+type LWWMap struct {
+	listeners
+	replica string
+
+	mu      sync.RWMutex
+	entries map[string]mapEntry
+}
+
+type mapEntry struct {
+	Value     string    `json:"value"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Timestamp timestamp `json:"timestamp"`
+}
+
+// NewLWWMap creates an empty map updated locally as replica
+func NewLWWMap(replica string) *LWWMap {
+	return &LWWMap{replica: replica, entries: make(map[string]mapEntry)}
+}
+
+// Set stores value under key
+func (m *LWWMap) Set(key, value string) {
+	m.write(key, mapEntry{Value: value})
+}
+
+// Delete removes key
+func (m *LWWMap) Delete(key string) {
+	m.write(key, mapEntry{Deleted: true})
+}
+
+func (m *LWWMap) write(key string, entry mapEntry) {
+	m.mu.Lock()
+	entry.Timestamp = next(m.replica, m.entries[key].Timestamp)
+	m.entries[key] = entry
+	m.mu.Unlock()
+
+	m.changed()
+}
+
+// Get returns the value stored under key
+func (m *LWWMap) Get(key string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, exists := m.entries[key]
+	if !exists || entry.Deleted {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+// Keys returns the keys that are present, in sorted order
+func (m *LWWMap) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.entries))
+	for key, entry := range m.entries {
+		if !entry.Deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Merge keeps the latest write for every key
+func (m *LWWMap) Merge(other *LWWMap) {
+	other.mu.RLock()
+	entries := make(map[string]mapEntry, len(other.entries))
+	for key, entry := range other.entries {
+		entries[key] = entry
+	}
+	other.mu.RUnlock()
+
+	m.merge(entries)
+}
+
+func (m *LWWMap) merge(entries map[string]mapEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, entry := range entries {
+		if entry.Timestamp.after(m.entries[key].Timestamp) {
+			m.entries[key] = entry
+		}
+	}
+}
+
+func (m *LWWMap) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return json.Marshal(m.entries)
+}
+
+func (m *LWWMap) MergeJSON(data []byte) error {
+	var entries map[string]mapEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to unmarshal lww-map: %v", err)
+	}
+	m.merge(entries)
+	return nil
+}
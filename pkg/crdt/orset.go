@@ -0,0 +1,155 @@
+package crdt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ORSet is an observed-remove set. Every add is tagged with a dot, the
+// adding replica's counter, and a remove only deletes the dots it has seen,
+// so an add that is concurrent with a remove of the same element wins.
+// Instead of keeping removed dots as tombstones, the set keeps the highest
+// counter it has seen per replica. A dot that is covered by the other
+// side's counters but missing from its adds was removed there, so the
+// state only grows with the elements present and the replicas.
+type ORSet struct {
+	listeners
+	replica string // the replica id and a random epoch
+
+	mu    sync.RWMutex
+	state orSetState
+}
+
+type orSetState struct {
+	Adds    map[string]map[string]uint64 `json:"adds"`    // element -> replica -> counter of its latest add
+	Context map[string]uint64            `json:"context"` // replica -> highest counter seen
+}
+
+// NewORSet creates an empty set updated locally as replica
+func NewORSet(replica string) *ORSet {
+	return &ORSet{
+		replica: newEpoch(replica),
+		state: orSetState{
+			Adds:    make(map[string]map[string]uint64),
+			Context: make(map[string]uint64),
+		},
+	}
+}
+
+// newEpoch returns a replica id that no set has used before. The counters
+// start at zero again when a replica restarts and loses its state, so each
+// set counts under an id of its own.
+func newEpoch(replica string) string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return replica + ":" + hex.EncodeToString(random)
+}
+
+// Add inserts element
+func (s *ORSet) Add(element string) {
+	s.mu.Lock()
+	s.state.Context[s.replica]++
+	// the new dot supersedes the ones this replica has observed
+	s.state.Adds[element] = map[string]uint64{s.replica: s.state.Context[s.replica]}
+	s.mu.Unlock()
+
+	s.changed()
+}
+
+// Remove deletes element as far as this replica has observed it
+func (s *ORSet) Remove(element string) {
+	s.mu.Lock()
+	delete(s.state.Adds, element)
+	s.mu.Unlock()
+
+	s.changed()
+}
+
+// Contains reports whether element is in the set
+func (s *ORSet) Contains(element string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.state.Adds[element]) > 0
+}
+
+// Elements returns the elements of the set in sorted order
+func (s *ORSet) Elements() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elements := make([]string, 0, len(s.state.Adds))
+	for element := range s.state.Adds {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// Merge keeps the dots both sides have and the ones the other side has not
+// seen yet
+func (s *ORSet) Merge(other *ORSet) {
+	data, err := other.MarshalJSON()
+	if err != nil {
+		return
+	}
+	s.MergeJSON(data)
+}
+
+func (s *ORSet) merge(other orSetState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elements := make(map[string]bool, len(s.state.Adds)+len(other.Adds))
+	for element := range s.state.Adds {
+		elements[element] = true
+	}
+	for element := range other.Adds {
+		elements[element] = true
+	}
+
+	for element := range elements {
+		ours, theirs := s.state.Adds[element], other.Adds[element]
+		dots := make(map[string]uint64)
+		for replica, counter := range ours {
+			if theirs[replica] == counter || counter > other.Context[replica] {
+				dots[replica] = counter
+			}
+		}
+		for replica, counter := range theirs {
+			if counter > dots[replica] && (ours[replica] == counter || counter > s.state.Context[replica]) {
+				dots[replica] = counter
+			}
+		}
+
+		if len(dots) == 0 {
+			delete(s.state.Adds, element)
+		} else {
+			s.state.Adds[element] = dots
+		}
+	}
+
+	for replica, counter := range other.Context {
+		if counter > s.state.Context[replica] {
+			s.state.Context[replica] = counter
+		}
+	}
+}
+
+func (s *ORSet) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return json.Marshal(s.state)
+}
+
+func (s *ORSet) MergeJSON(data []byte) error {
+	var state orSetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to unmarshal or-set: %v", err)
+	}
+	s.merge(state)
+	return nil
+}
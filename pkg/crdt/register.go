@@ -0,0 +1,76 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// LWWRegister holds a single value. Concurrent writes are resolved by
+// keeping the one with the latest timestamp.
+type LWWRegister struct {
+	listeners
+	replica string
+
+	mu    sync.RWMutex
+	state registerState
+}
+
+type registerState struct {
+	Value     string    `json:"value"`
+	Timestamp timestamp `json:"timestamp"`
+}
+
+// NewLWWRegister creates an empty register written locally as replica
+func NewLWWRegister(replica string) *LWWRegister {
+	return &LWWRegister{replica: replica}
+}
+
+// Set stores value
+func (r *LWWRegister) Set(value string) {
+	r.mu.Lock()
+	r.state = registerState{Value: value, Timestamp: next(r.replica, r.state.Timestamp)}
+	r.mu.Unlock()
+
+	r.changed()
+}
+
+// Get returns the value and whether it was ever set
+func (r *LWWRegister) Get() (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.Value, r.state.Timestamp.Time != 0
+}
+
+// Merge keeps the later of the two values
+func (r *LWWRegister) Merge(other *LWWRegister) {
+	other.mu.RLock()
+	state := other.state
+	other.mu.RUnlock()
+
+	r.merge(state)
+}
+
+func (r *LWWRegister) merge(state registerState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if state.Timestamp.after(r.state.Timestamp) {
+		r.state = state
+	}
+}
+
+func (r *LWWRegister) MarshalJSON() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return json.Marshal(r.state)
+}
+
+func (r *LWWRegister) MergeJSON(data []byte) error {
+	var state registerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to unmarshal lww-register: %v", err)
+	}
+	r.merge(state)
+	return nil
+}
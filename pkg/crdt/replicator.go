@@ -0,0 +1,228 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/gossip"
+	log "github.com/sirupsen/logrus"
+)
+
+// contentType marks gossip rumors that carry a CRDT state
+const contentType = "application/x-crdt+json"
+
+// DefaultSyncInterval is how often a replicator checks for states to gossip again
+const DefaultSyncInterval = time.Second
+
+// update is what travels in a rumor
+type update struct {
+	Name  string          `json:"name"`
+	State json.RawMessage `json:"state"`
+}
+
+// Replicator keeps named CRDTs in sync with the other replicators of the
+// cluster. Local updates are gossiped right away. Replicas that were cut off
+// by a partition catch up through the gossip node's anti-entropy once it
+// heals, which fetches the rumors they missed. A state that did not change
+// is only gossiped again before its last rumor expires, so anti-entropy
+// always has a copy of it.
+type Replicator struct {
+	gossip *gossip.GossipNode
+
+	mu           sync.RWMutex
+	objects      map[string]CRDT
+	published    map[string]publication // last state gossiped or received per name
+	syncInterval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// publication is a state that is known to be on its way through the cluster
+type publication struct {
+	state string
+	at    time.Time // creation time of the rumor that carries it
+}
+
+// New creates a replicator on top of gn and turns on anti-entropy there
+func New(gn *gossip.GossipNode) *Replicator {
+	r := &Replicator{
+		gossip:       gn,
+		objects:      make(map[string]CRDT),
+		published:    make(map[string]publication),
+		syncInterval: DefaultSyncInterval,
+		stop:         make(chan struct{}),
+	}
+
+	gn.EnableAntiEntropy(true)
+	gn.OnDeliver(r.handleRumor)
+
+	return r
+}
+
+// Replica returns the id to create CRDTs with, so that every replica
+// updates its own part of their state
+func (r *Replicator) Replica() string {
+	return r.gossip.NodeID().String()
+}
+
+// SetSyncInterval sets how often states are checked for gossiping again. It
+// must be called before Start.
+func (r *Replicator) SetSyncInterval(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncInterval = interval
+}
+
+// Register replicates obj under name. States for the name that arrived
+// before registration are not kept; they come again with the next sync.
+func (r *Replicator) Register(name string, obj CRDT) error {
+	r.mu.Lock()
+	if _, exists := r.objects[name]; exists {
+		r.mu.Unlock()
+		return fmt.Errorf("crdt %s is already registered", name)
+	}
+	r.objects[name] = obj
+	r.mu.Unlock()
+
+	obj.OnChange(func() {
+		if err := r.Publish(name); err != nil {
+			r.gossip.Logger().WithError(err).WithField("CRDT", name).Warn("Failed to publish update")
+		}
+	})
+
+	return nil
+}
+
+// Get returns the CRDT registered under name
+func (r *Replicator) Get(name string) (CRDT, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	obj, exists := r.objects[name]
+	return obj, exists
+}
+
+// Publish gossips the current state of the CRDT registered under name,
+// unless it is the state last gossiped or received
+func (r *Replicator) Publish(name string) error {
+	return r.publish(name, 0)
+}
+
+// publish gossips the state of name if it changed, or if maxAge is positive
+// and the rumor that last carried it is older than that
+func (r *Replicator) publish(name string, maxAge time.Duration) error {
+	obj, exists := r.Get(name)
+	if !exists {
+		return fmt.Errorf("crdt %s is not registered", name)
+	}
+
+	state, err := obj.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal crdt %s: %v", name, err)
+	}
+
+	r.mu.RLock()
+	last, known := r.published[name]
+	r.mu.RUnlock()
+	if known && last.state == string(state) && (maxAge <= 0 || time.Since(last.at) < maxAge) {
+		return nil
+	}
+
+	payload, err := json.Marshal(update{Name: name, State: state})
+	if err != nil {
+		return fmt.Errorf("failed to marshal update: %v", err)
+	}
+	if _, err := r.gossip.GossipBytes(payload, contentType); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.published[name] = publication{state: string(state), at: time.Now()}
+	r.mu.Unlock()
+	return nil
+}
+
+// Sync gossips the states that changed since they were last gossiped or
+// received, and the ones whose last rumor would expire before the next sync
+func (r *Replicator) Sync() error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.objects))
+	for name := range r.objects {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	// the rumor must still be stored somewhere when anti-entropy needs it
+	maxAge := r.gossip.Config().Retention / 2
+	for _, name := range names {
+		if err := r.publish(name, maxAge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start syncs the states every sync interval until Stop is called
+func (r *Replicator) Start() {
+	r.mu.RLock()
+	interval := r.syncInterval
+	r.mu.RUnlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if err := r.Sync(); err != nil {
+					r.gossip.Logger().WithError(err).Warn("Failed to sync CRDT states")
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic sync
+func (r *Replicator) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+func (r *Replicator) handleRumor(msg gossip.GossipMessage) {
+	if msg.ContentType != contentType {
+		return // an ordinary rumor
+	}
+
+	data, err := msg.Data()
+	if err != nil {
+		return
+	}
+	var u update
+	if err := json.Unmarshal(data, &u); err != nil {
+		return
+	}
+
+	obj, exists := r.Get(u.Name)
+	if !exists {
+		return
+	}
+	if err := obj.MergeJSON(u.State); err != nil {
+		r.gossip.Logger().WithFields(log.Fields{"CRDT": u.Name, "MsgID": msg.ID}).WithError(err).Warn("Failed to merge state")
+		return
+	}
+
+	// a replica that has nothing to add does not gossip the state again
+	state, err := obj.MarshalJSON()
+	if err != nil || string(state) != string(u.State) {
+		return
+	}
+	r.mu.Lock()
+	if last, known := r.published[u.Name]; !known || last.state != string(state) || last.at.Before(msg.Timestamp) {
+		r.published[u.Name] = publication{state: string(state), at: msg.Timestamp}
+	}
+	r.mu.Unlock()
+}
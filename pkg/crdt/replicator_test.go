@@ -0,0 +1,173 @@
+package crdt
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/gossip"
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// replica bundles the CRDTs of one node in the test cluster
+type replica struct {
+	replicator *Replicator
+	counter    *PNCounter
+	flags      *LWWMap
+	tags       *ORSet
+}
+
+func newCluster(t *testing.T, net network.Network, size int) ([]*gossip.GossipNode, []replica) {
+	nodes := make([]*gossip.GossipNode, size)
+	replicas := make([]replica, size)
+
+	for i := 0; i < size; i++ {
+		config := gossip.DefaultGossipConfig()
+		config.BindAddress.Port = 8000 + i
+		gn, err := gossip.NewGossipNode(net, i, config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = gn
+
+		r := New(gn)
+		r.SetSyncInterval(100 * time.Millisecond)
+		replicas[i] = replica{
+			replicator: r,
+			counter:    NewPNCounter(r.Replica()),
+			flags:      NewLWWMap(r.Replica()),
+			tags:       NewORSet(r.Replica()),
+		}
+		r.Register("counter", replicas[i].counter)
+		r.Register("flags", replicas[i].flags)
+		r.Register("tags", replicas[i].tags)
+	}
+
+	// full mesh
+	for i, gn := range nodes {
+		for j, peer := range nodes {
+			if i != j {
+				gn.AddPeer(peer.Address())
+			}
+		}
+	}
+	for i, gn := range nodes {
+		gn.Start()
+		replicas[i].replicator.Start()
+	}
+
+	t.Cleanup(func() {
+		for i, gn := range nodes {
+			replicas[i].replicator.Stop()
+			gn.Close()
+		}
+	})
+	return nodes, replicas
+}
+
+func TestReplicasConvergeAfterPartition(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, replicas := newCluster(t, net, 4)
+
+	replicas[0].flags.Set("beta", "on")
+	replicas[0].tags.Add("eu")
+
+	// cut off the last node and update both sides
+	isolated := []network.Address{nodes[3].Address()}
+	net.Partition(isolated, nil)
+
+	replicas[0].counter.Increment(2)
+	replicas[1].counter.Increment(3)
+	replicas[3].counter.Decrement(1)
+	replicas[1].flags.Set("dark", "off")
+	replicas[3].tags.Add("us")
+
+	time.Sleep(300 * time.Millisecond)
+	if replicas[3].counter.Value() == 4 {
+		t.Fatalf("expected the isolated replica to miss updates during the partition")
+	}
+
+	net.Heal()
+
+	converged := func() bool {
+		for _, r := range replicas {
+			if r.counter.Value() != 4 ||
+				!reflect.DeepEqual(r.flags.Keys(), []string{"beta", "dark"}) ||
+				!reflect.DeepEqual(r.tags.Elements(), []string{"eu", "us"}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !converged() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !converged() {
+		for i, r := range replicas {
+			t.Errorf("replica %d: counter %d, flags %v, tags %v", i, r.counter.Value(), r.flags.Keys(), r.tags.Elements())
+		}
+	}
+}
+
+func TestUnchangedStatesAreNotGossipedAgain(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, replicas := newCluster(t, net, 3)
+
+	replicas[0].counter.Increment(1)
+	deadline := time.Now().Add(time.Second)
+	for replicas[2].counter.Value() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the first sync gossips the states that were never gossiped
+	time.Sleep(150 * time.Millisecond)
+	before := nodes[0].Stats().Stored
+
+	// several sync intervals pass without an update
+	time.Sleep(350 * time.Millisecond)
+	for i, gn := range nodes {
+		if stored := gn.Stats().Stored; stored != before {
+			t.Errorf("node %d stores %d rumors, expected %d", i, stored, before)
+		}
+	}
+
+	replicas[1].tags.Add("eu")
+	time.Sleep(150 * time.Millisecond)
+	if stored := nodes[0].Stats().Stored; stored != before+1 {
+		t.Errorf("expected one more rumor for the update, got %d after %d", stored, before)
+	}
+}
+
+func TestSetKeepsReplicatingAfterManyRemoves(t *testing.T) {
+	net := network.NewMockNetwork()
+	_, replicas := newCluster(t, net, 2)
+
+	// removed elements must not pile up in the state until it is too large to
+	// gossip. The churn happens on a copy, so it is not gossiped step by step.
+	churned := NewORSet(replicas[0].replicator.Replica())
+	for i := 0; i < 2000; i++ {
+		element := fmt.Sprintf("element-%d", i)
+		churned.Add(element)
+		churned.Remove(element)
+	}
+	replicas[0].tags.Merge(churned)
+	replicas[0].tags.Add("kept")
+
+	state, err := replicas[0].tags.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state) > 1024 {
+		t.Errorf("expected a set with one element to stay small, its state has %d bytes", len(state))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !reflect.DeepEqual(replicas[1].tags.Elements(), []string{"kept"}) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := replicas[1].tags.Elements(); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("expected the other replica to hold [kept], got %d elements", len(got))
+	}
+}
@@ -0,0 +1,263 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// Aggregation uses push-sum. Every node holds a share of three masses: its
+// value s, a weight w that starts at 1, and an indicator c that starts at 1
+// on the node that started the aggregation and 0 everywhere else. Each round
+// a node keeps half of its shares and pushes the other half to a random
+// peer ("agg-push"). The totals never change, so every node's ratios
+// converge to
+//
+//	s/w = average of the values
+//	w/c = number of nodes
+//	s/c = sum of the values
+//
+// Minimum and maximum simply travel along with the shares. Peers that pushed
+// to a node are pushed to in turn, so a node that is in no peer list still
+// receives shares. A sender is forgotten when it is removed as a peer or has
+// not pushed for aggregateWindow rounds.
+
+// aggregateWindow is the number of rounds the error bound looks back on
+const aggregateWindow = 5
+
+// AggregateResult is a node's current estimate of an aggregate
+type AggregateResult struct {
+	Name    string
+	Average float64
+	Sum     float64
+	Count   float64 // estimated number of nodes taking part
+	Min     float64
+	Max     float64
+	Rounds  int     // push-sum rounds this node took part in
+	Error   float64 // largest relative change of the estimates over the last rounds
+}
+
+// aggregateShare is what a node pushes to a peer
+type aggregateShare struct {
+	Name  string  `json:"name"`
+	S     float64 `json:"s"`
+	C     float64 `json:"c"`
+	W     float64 `json:"w"`
+	Range bool    `json:"range"` // whether Min and Max hold seen values
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// aggregateState is a node's part of one aggregation
+type aggregateState struct {
+	s, c, w  float64
+	hasRange bool
+	min, max float64
+	value    float64 // local value included in s
+	hasValue bool
+	rounds   int
+	history  []AggregateResult // estimates of the last rounds, newest last
+}
+
+// SetLocalValue sets this node's value for the aggregate name. It can be
+// called again at any time; the estimates follow the new value, except the
+// minimum and maximum, which only ever widen.
+func (gn *GossipNode) SetLocalValue(name string, value float64) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	state := gn.aggregateState(name)
+	if state.hasValue {
+		state.s += value - state.value
+	} else {
+		state.s += value
+		state.w++
+		state.hasValue = true
+	}
+	state.value = value
+	state.widen(value, value)
+}
+
+// StartAggregation makes this node the initiator of the aggregate name.
+// Exactly one node per aggregate must call it, otherwise the size and sum
+// estimates are off by the number of initiators.
+func (gn *GossipNode) StartAggregation(name string) error {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	if state, exists := gn.aggregates[name]; exists && state.c > 0 {
+		return fmt.Errorf("aggregation %s was already started", name)
+	}
+	gn.aggregateState(name).c = 1
+	return nil
+}
+
+// Aggregate returns this node's current estimate of the aggregate name, and
+// false if the node has not heard of it
+func (gn *GossipNode) Aggregate(name string) (AggregateResult, bool) {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	state, exists := gn.aggregates[name]
+	if !exists {
+		return AggregateResult{}, false
+	}
+	return state.result(name), true
+}
+
+// aggregateState returns the state of the aggregate name, creating it if
+// needed. Must be called with gn.mu held.
+func (gn *GossipNode) aggregateState(name string) *aggregateState {
+	state, exists := gn.aggregates[name]
+	if !exists {
+		state = &aggregateState{}
+		gn.aggregates[name] = state
+	}
+	return state
+}
+
+// widen extends the range of seen values to include min and max
+func (state *aggregateState) widen(min, max float64) {
+	if !state.hasRange {
+		state.min, state.max, state.hasRange = min, max, true
+		return
+	}
+	state.min = math.Min(state.min, min)
+	state.max = math.Max(state.max, max)
+}
+
+// result computes the estimates from the current shares
+func (state *aggregateState) result(name string) AggregateResult {
+	result := AggregateResult{
+		Name:   name,
+		Min:    state.min,
+		Max:    state.max,
+		Rounds: state.rounds,
+		Error:  math.Inf(1),
+	}
+	if state.w > 0 {
+		result.Average = state.s / state.w
+	}
+	if state.c > 0 {
+		result.Count = state.w / state.c
+		result.Sum = state.s / state.c
+	}
+
+	// the error bound needs a full window of estimates
+	if len(state.history) < aggregateWindow {
+		return result
+	}
+	result.Error = 0
+	for _, past := range state.history {
+		result.Error = math.Max(result.Error, relativeChange(past.Average, result.Average))
+		result.Error = math.Max(result.Error, relativeChange(past.Count, result.Count))
+		result.Error = math.Max(result.Error, relativeChange(past.Sum, result.Sum))
+	}
+	return result
+}
+
+func relativeChange(past, current float64) float64 {
+	if past == current {
+		return 0
+	}
+	return math.Abs(current-past) / math.Max(math.Abs(past), math.Abs(current))
+}
+
+// setupAggregation registers the push-sum message handler
+func (gn *GossipNode) setupAggregation() {
+	gn.node.Handle("agg-push", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var share aggregateShare
+		if err := json.Unmarshal(data, &share); err != nil {
+			return fmt.Errorf("failed to unmarshal aggregate share: %v", err)
+		}
+
+		gn.mu.Lock()
+		defer gn.mu.Unlock()
+		gn.aggregateSenders[msg.From] = time.Now()
+		state := gn.aggregateState(share.Name)
+		state.s += share.S
+		state.c += share.C
+		state.w += share.W
+		if share.Range {
+			state.widen(share.Min, share.Max)
+		}
+		return nil
+	})
+}
+
+// aggregateRound pushes half of every aggregate's shares to a random peer
+func (gn *GossipNode) aggregateRound() {
+	gn.mu.Lock()
+	peers := make([]network.Address, len(gn.peers), len(gn.peers)+len(gn.aggregateSenders))
+	copy(peers, gn.peers)
+	stale := time.Now().Add(-aggregateWindow * gn.roundInterval)
+	for addr, pushed := range gn.aggregateSenders {
+		if pushed.Before(stale) {
+			delete(gn.aggregateSenders, addr)
+			continue
+		}
+		if !containsAddress(gn.peers, addr) {
+			peers = append(peers, addr)
+		}
+	}
+	if len(peers) == 0 {
+		gn.mu.Unlock()
+		return
+	}
+
+	shares := make([]aggregateShare, 0, len(gn.aggregates))
+	for name, state := range gn.aggregates {
+		state.s /= 2
+		state.c /= 2
+		state.w /= 2
+		state.rounds++
+		shares = append(shares, aggregateShare{Name: name, S: state.s, C: state.c, W: state.w, Range: state.hasRange, Min: state.min, Max: state.max})
+	}
+	gn.mu.Unlock()
+
+	for _, share := range shares {
+		peer := peers[mathrand.Intn(len(peers))]
+		data, err := json.Marshal(share)
+		if err == nil {
+			// no retries in the background, a share they lose is lost for good
+			err = gn.node.SendOnce(peer, "agg-push", data)
+		}
+		if err != nil {
+			// keep the share, the totals must not change
+			gn.Logger().WithFields(log.Fields{"Aggregate": share.Name, "Peer": peer.String()}).WithError(err).Debug("Failed to push aggregate share")
+			gn.mu.Lock()
+			state := gn.aggregates[share.Name]
+			state.s += share.S
+			state.c += share.C
+			state.w += share.W
+			gn.mu.Unlock()
+		}
+	}
+
+	// record the estimates for the error bound
+	gn.mu.Lock()
+	for name, state := range gn.aggregates {
+		state.history = append(state.history, state.result(name))
+		if len(state.history) > aggregateWindow {
+			state.history = state.history[1:]
+		}
+	}
+	gn.mu.Unlock()
+}
+
+func containsAddress(addrs []network.Address, addr network.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package gossip
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestPushSumAggregation(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(30); err != nil {
+		t.Fatal(err)
+	}
+	builder.BuildRandomTopology(4)
+
+	nodes := builder.GetNodes()
+	for i, node := range nodes {
+		node.SetRoundInterval(10 * time.Millisecond)
+		node.SetLocalValue("load", float64(i))
+	}
+	if err := nodes[0].StartAggregation("load"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].StartAggregation("load"); err == nil {
+		t.Errorf("expected starting the same aggregation twice to fail")
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	converged := func() bool {
+		for _, node := range nodes {
+			if result, _ := node.Aggregate("load"); result.Error > 1e-4 {
+				return false
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !converged() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// values 0..29: average 14.5, sum 435
+	for _, node := range nodes {
+		result, known := node.Aggregate("load")
+		if !known {
+			t.Fatalf("node %d never heard of the aggregate", node.GetID())
+		}
+		if math.Abs(result.Average-14.5) > 0.01 || math.Abs(result.Count-30) > 0.03 || math.Abs(result.Sum-435) > 0.5 {
+			t.Errorf("node %d estimated average %.3f, count %.3f, sum %.3f", node.GetID(), result.Average, result.Count, result.Sum)
+		}
+		if result.Min != 0 || result.Max != 29 {
+			t.Errorf("node %d estimated range [%v, %v]", node.GetID(), result.Min, result.Max)
+		}
+		if result.Error > 1e-4 {
+			t.Errorf("node %d did not converge, error bound %v", node.GetID(), result.Error)
+		}
+	}
+}
+
+func TestAggregateSendersAreForgotten(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(3); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		node.SetRoundInterval(10 * time.Millisecond)
+		node.SetLocalValue("load", 1)
+	}
+	// node 0 knows nobody and only pushes back to the nodes that push to it
+	nodes[1].AddPeer(nodes[0].Address())
+	nodes[2].AddPeer(nodes[0].Address())
+	nodes[0].StartAggregation("load")
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	sender := func(addr network.Address) bool {
+		nodes[0].mu.RLock()
+		defer nodes[0].mu.RUnlock()
+		_, exists := nodes[0].aggregateSenders[addr]
+		return exists
+	}
+	waitFor(t, func() bool { return sender(nodes[1].Address()) && sender(nodes[2].Address()) })
+
+	// a removed peer is forgotten right away
+	nodes[1].Close()
+	time.Sleep(20 * time.Millisecond) // shares still in flight
+	nodes[0].RemovePeer(nodes[1].Address())
+	if sender(nodes[1].Address()) {
+		t.Errorf("expected a removed peer to be forgotten")
+	}
+
+	// a sender that went silent is forgotten after a few rounds
+	nodes[2].Close()
+	waitFor(t, func() bool { return !sender(nodes[2].Address()) })
+}
+
+// busyNetwork fails every send with a transient error, which the node retries
+type busyNetwork struct {
+	network.Network
+}
+
+func (n busyNetwork) Dial(addr network.Address) (network.Connection, error) {
+	conn, err := n.Network.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return busyConnection{conn}, nil
+}
+
+type busyConnection struct {
+	network.Connection
+}
+
+func (c busyConnection) Send(msg network.Message) error {
+	return network.ErrQueueFull
+}
+
+func TestAggregateKeepsSharesThatFailed(t *testing.T) {
+	net := busyNetwork{network.NewMockNetwork()}
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	nodes[0].SetRoundInterval(10 * time.Millisecond)
+	nodes[0].SetLocalValue("load", 5)
+	nodes[0].StartAggregation("load")
+	nodes[0].AddPeer(nodes[1].Address())
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// the retries in the background fail too, the shares must stay
+	time.Sleep(300 * time.Millisecond)
+
+	nodes[0].mu.RLock()
+	state := *nodes[0].aggregates["load"]
+	nodes[0].mu.RUnlock()
+	if state.s != 5 || state.c != 1 || state.w != 1 {
+		t.Errorf("expected the masses to be kept, got s=%v c=%v w=%v", state.s, state.c, state.w)
+	}
+}
@@ -0,0 +1,266 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// Anti-entropy runs alongside rumor spreading. Every round the node sends a
+// digest of the messages it holds to a random peer ("ae-digest"). The peer
+// pushes back whatever the node is missing ("ae-push") and asks for whatever
+// it is missing itself ("ae-pull"), so both sides end up with the union.
+// A digest covers one range of ids, sorted as strings, so that it fits in a
+// datagram. Each round covers the range after the previous one, wrapping
+// around at the end. Pushes are split the same way. A restarted node marks
+// the last page of its catch-up digest, and the peer confirms it with an
+// "ae-caught-up" after its pushes.
+
+// digest lists the ids of the messages a node holds in the range [From, To).
+// An empty From is the start of the range, an empty To the end.
+type digest struct {
+	IDs     []string `json:"ids"`
+	From    string   `json:"from,omitempty"`
+	To      string   `json:"to,omitempty"`
+	CatchUp bool     `json:"catch_up,omitempty"` // answer with "ae-caught-up"
+}
+
+// contains reports whether id lies in the digest's range
+func (d digest) contains(id string) bool {
+	return id >= d.From && (d.To == "" || id < d.To)
+}
+
+// EnableAntiEntropy turns periodic push-pull anti-entropy rounds on or off
+func (gn *GossipNode) EnableAntiEntropy(enabled bool) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.antiEntropy = enabled
+}
+
+// setupAntiEntropy registers the anti-entropy message handlers
+func (gn *GossipNode) setupAntiEntropy() {
+	gn.node.Handle("ae-digest", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var remote digest
+		if err := json.Unmarshal(data, &remote); err != nil {
+			return fmt.Errorf("failed to unmarshal digest: %v", err)
+		}
+
+		// push what the peer is missing
+		if missing := gn.messagesNotIn(remote); len(missing) > 0 {
+			if err := gn.sendMessages(msg.From, "ae-push", missing); err != nil {
+				return err
+			}
+		}
+
+		// pull what we are missing
+		if wanted := gn.unseen(remote.IDs); len(wanted) > 0 {
+			request, err := json.Marshal(digest{IDs: wanted})
+			if err != nil {
+				return fmt.Errorf("failed to marshal pull request: %v", err)
+			}
+			if err := gn.node.Send(msg.From, "ae-pull", request); err != nil {
+				return err
+			}
+		}
+
+		if remote.CatchUp {
+			return gn.node.SendString(msg.From, "ae-caught-up", "")
+		}
+		return nil
+	})
+
+	gn.node.Handle("ae-caught-up", func(msg network.Message) error {
+		gn.caughtUp(msg.From)
+		return nil
+	})
+
+	gn.node.Handle("ae-pull", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var request digest
+		if err := json.Unmarshal(data, &request); err != nil {
+			return fmt.Errorf("failed to unmarshal pull request: %v", err)
+		}
+		return gn.sendMessages(msg.From, "ae-push", gn.messagesIn(request.IDs))
+	})
+
+	gn.node.Handle("ae-push", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var messages []GossipMessage
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("failed to unmarshal pushed messages: %v", err)
+		}
+
+		forwarder, _ := node.SenderID(msg)
+		for _, gossipmsg := range messages {
+			// repaired messages are delivered, but not spread as new rumors
+			gossipmsg.Hops++
+			gn.receive(gossipmsg, forwarder, "ae-push")
+		}
+		return nil
+	})
+}
+
+// antiEntropyRound starts a digest exchange with a random peer
+func (gn *GossipNode) antiEntropyRound() {
+	gn.mu.RLock()
+	enabled := gn.antiEntropy
+	gn.mu.RUnlock()
+
+	peers := gn.GetPeers()
+	if !enabled || len(peers) == 0 {
+		return
+	}
+
+	gn.mu.Lock()
+	page := gn.digestPage(gn.digestCursor)
+	gn.digestCursor = page.To
+	gn.mu.Unlock()
+
+	gn.sendDigest(peers[mathrand.Intn(len(peers))], page)
+}
+
+// sendDigest starts a digest exchange with peer
+func (gn *GossipNode) sendDigest(peer network.Address, page digest) {
+	data, err := json.Marshal(page)
+	if err != nil {
+		gn.Logger().WithError(err).Error("Failed to marshal digest")
+		return
+	}
+
+	if err := gn.node.Send(peer, "ae-digest", data); err != nil {
+		gn.Logger().WithFields(log.Fields{"Peer": peer.String()}).WithError(err).Debug("Anti-entropy exchange failed")
+	}
+}
+
+// digestPage lists the held ids from from on, as many as fit in a page.
+// Must be called with gn.mu held.
+func (gn *GossipNode) digestPage(from string) digest {
+	ids := make([]string, 0, len(gn.receivedMsgs))
+	for _, msg := range gn.receivedMsgs {
+		if msg.ID >= from {
+			ids = append(ids, msg.ID)
+		}
+	}
+	sort.Strings(ids)
+
+	page := digest{IDs: make([]string, 0), From: from}
+	limit := gn.pageBytes()
+	size := 0
+	for _, id := range ids {
+		// the quotes and the comma around the id
+		size += len(id) + 3
+		if size > limit && len(page.IDs) > 0 {
+			page.To = id
+			break
+		}
+		page.IDs = append(page.IDs, id)
+	}
+	return page
+}
+
+// unseen returns the ids this node has never seen
+func (gn *GossipNode) unseen(ids []string) []string {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if !gn.seen.contains(id) {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// digestPages lists all held ids, page by page. Must be called with gn.mu held.
+func (gn *GossipNode) digestPages() []digest {
+	pages := []digest{gn.digestPage("")}
+	for pages[len(pages)-1].To != "" {
+		pages = append(pages, gn.digestPage(pages[len(pages)-1].To))
+	}
+	return pages
+}
+
+// messagesIn returns the held messages whose ids are listed
+func (gn *GossipNode) messagesIn(ids []string) []GossipMessage {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	messages := make([]GossipMessage, 0)
+	for _, msg := range gn.receivedMsgs {
+		if wanted[msg.ID] {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// messagesNotIn returns the held messages in the digest's range whose ids
+// it does not list
+func (gn *GossipNode) messagesNotIn(remote digest) []GossipMessage {
+	known := make(map[string]bool, len(remote.IDs))
+	for _, id := range remote.IDs {
+		known[id] = true
+	}
+
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	messages := make([]GossipMessage, 0)
+	for _, msg := range gn.receivedMsgs {
+		if remote.contains(msg.ID) && !known[msg.ID] {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// sendMessages sends full messages to a peer, as many per message as fit in a page
+func (gn *GossipNode) sendMessages(to network.Address, msgType string, messages []GossipMessage) error {
+	gn.mu.RLock()
+	limit := gn.pageBytes()
+	gn.mu.RUnlock()
+
+	page := make([][]byte, 0)
+	size := 0
+	for _, msg := range messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %v", err)
+		}
+		if size+len(data)+1 > limit && len(page) > 0 {
+			if err := gn.sendPage(to, msgType, page); err != nil {
+				return err
+			}
+			page, size = page[:0], 0
+		}
+		page = append(page, data)
+		size += len(data) + 1
+	}
+	if len(page) == 0 {
+		return nil
+	}
+	return gn.sendPage(to, msgType, page)
+}
+
+// sendPage sends marshaled messages as one JSON array
+func (gn *GossipNode) sendPage(to network.Address, msgType string, page [][]byte) error {
+	data := append([]byte{'['}, bytes.Join(page, []byte{','})...)
+	data = append(data, ']')
+	return gn.node.Send(to, msgType, data)
+}
@@ -0,0 +1,289 @@
+package gossip
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// With batching, rumors pushed to a peer and Plumtree announcements for it
+// wait in the peer's outbox until the batch is full, the next entry would
+// not fit in one datagram or the batch interval has passed since the first
+// entry. The outbox then travels as a single "gossip-batch" message.
+// Plumtree prunes and grafts travel in the batch too, and so do the messages
+// other protocols hand to Send, such as HyParView membership messages. Every
+// batch carries the Piggybacker's data, such as SWIM membership updates. The
+// receiver dispatches the rumors and the other protocols' messages to their
+// handlers as if they had arrived on their own. A batch size of one or less
+// sends every message on its own.
+
+// DefaultBatchInterval is how long an entry waits in a batch at most
+const DefaultBatchInterval = 20 * time.Millisecond
+
+// piggybackReserve is the room left in a batch for the piggybacked data and
+// the batch's own fields
+const piggybackReserve = 4 * 1024
+
+// maxBatchBytes is how large the JSON encoded entries of a batch may grow.
+// An entry that would go over it is put in the next batch, so that the batch
+// fits in one datagram.
+const maxBatchBytes = maxDatagramPayload - piggybackReserve
+
+// batch is what travels in a "gossip-batch" message
+type batch struct {
+	Messages  []GossipMessage  `json:"messages,omitempty"`
+	IHave     []string         `json:"ihave,omitempty"`
+	Graft     []string         `json:"graft,omitempty"`
+	Prune     bool             `json:"prune,omitempty"`
+	Other     []batchedMessage `json:"other,omitempty"`
+	Piggyback json.RawMessage  `json:"piggyback,omitempty"`
+}
+
+// batchedMessage is a message of another protocol that travels in a batch
+type batchedMessage struct {
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// outbox collects the next batch for a peer
+type outbox struct {
+	batch batch
+	bytes int
+	timer *time.Timer
+}
+
+// SetBatching coalesces up to size messages per peer, each waiting at most
+// interval. A size of one or less turns batching off.
+func (gn *GossipNode) SetBatching(size int, interval time.Duration) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.batchSize = size
+	gn.batchInterval = interval
+}
+
+// setupBatching registers the handler for batches
+func (gn *GossipNode) setupBatching() {
+	gn.node.Handle("gossip-batch", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var b batch
+		if err := json.Unmarshal(data, &b); err != nil {
+			return fmt.Errorf("failed to unmarshal batch: %v", err)
+		}
+
+		if _, ok := node.SenderID(msg); !ok {
+			return fmt.Errorf("batch from %s has no sender id", msg.From.String())
+		}
+
+		// the rumors and other messages are rate limited like those sent on their own
+		gn.receivePiggyback(b.Piggyback, msg.From)
+		for _, gossipmsg := range b.Messages {
+			data, err := json.Marshal(gossipmsg)
+			if err != nil {
+				return fmt.Errorf("failed to marshal batched message: %v", err)
+			}
+			gn.dispatchUnpacked(msg, "gossip", data)
+		}
+		if len(b.IHave) > 0 {
+			gn.announced(b.IHave, msg.From)
+		}
+		if b.Prune {
+			gn.mu.Lock()
+			gn.lazy[msg.From] = true
+			gn.mu.Unlock()
+		}
+		if len(b.Graft) > 0 {
+			gn.grafted(b.Graft, msg.From)
+		}
+		for _, other := range b.Other {
+			gn.dispatchUnpacked(msg, other.Type, other.Data)
+		}
+		return nil
+	})
+}
+
+// dispatchUnpacked hands a message taken out of the batch msg to its handler
+func (gn *GossipNode) dispatchUnpacked(msg network.Message, msgType string, data []byte) {
+	unpacked := msg
+	unpacked.Payload = payload(msgType, data)
+	gn.node.Dispatch(unpacked)
+}
+
+// Send sends a message of another protocol to addr, in the next batch for
+// addr if batching is on. Protocols such as HyParView and SWIM send through
+// it when given the gossip node as their sender.
+func (gn *GossipNode) Send(addr network.Address, msgType string, data []byte) error {
+	size := len(msgType) + base64.StdEncoding.EncodedLen(len(data)) + 24
+	queued := gn.queue(addr, size, func(b *batch) {
+		b.Other = append(b.Other, batchedMessage{Type: msgType, Data: data})
+	})
+	if queued {
+		return nil
+	}
+	return gn.node.Send(addr, msgType, data)
+}
+
+// payload formats a message type and data like node.Node does on the wire
+func payload(msgType string, data []byte) []byte {
+	return append([]byte(msgType+":"), data...)
+}
+
+// queueMessage adds msg to the batch for addr and reports whether it did.
+// Without batching the caller sends msg on its own.
+func (gn *GossipNode) queueMessage(addr network.Address, msg GossipMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	return gn.queue(addr, len(data)+1, func(b *batch) {
+		b.Messages = append(b.Messages, msg)
+	})
+}
+
+// queuePrune adds a Plumtree prune to the batch for addr and reports whether it did
+func (gn *GossipNode) queuePrune(addr network.Address) bool {
+	return gn.queue(addr, 0, func(b *batch) {
+		b.Prune = true
+	})
+}
+
+// queueGraft adds a Plumtree graft to the batch for addr and reports whether it did
+func (gn *GossipNode) queueGraft(addr network.Address, id string) bool {
+	return gn.queue(addr, len(id)+3, func(b *batch) {
+		b.Graft = append(b.Graft, id)
+	})
+}
+
+// queue adds an entry to the batch for addr and sends the batch if it is full
+func (gn *GossipNode) queue(addr network.Address, size int, add func(*batch)) bool {
+	gn.mu.Lock()
+	if gn.batchSize <= 1 || gn.closed() {
+		gn.mu.Unlock()
+		return false
+	}
+	due := gn.enqueue(addr, size, add)
+	gn.mu.Unlock()
+
+	for _, b := range due {
+		gn.sendBatch(addr, b)
+	}
+	return true
+}
+
+// queueAnnouncement adds the id to the batches for the lazy peers and
+// returns the peers that still need a "pt-ihave" of their own
+func (gn *GossipNode) queueAnnouncement(lazy []network.Address, id string) []network.Address {
+	gn.mu.Lock()
+	if gn.batchSize <= 1 || gn.closed() {
+		gn.mu.Unlock()
+		return lazy
+	}
+	due := make(map[network.Address][]batch)
+	for _, peer := range lazy {
+		if b := gn.enqueue(peer, len(id)+3, func(b *batch) {
+			b.IHave = append(b.IHave, id)
+		}); len(b) > 0 {
+			due[peer] = b
+		}
+	}
+	gn.mu.Unlock()
+
+	for peer, batches := range due {
+		gn.goSend(func() {
+			for _, b := range batches {
+				gn.sendBatch(peer, b)
+			}
+		})
+	}
+	return nil
+}
+
+// enqueue adds an entry of the given encoded size to the outbox for addr and
+// returns the batches that are due now: the pending one if the entry does not
+// fit in it, and the new one if it is full. Must be called with gn.mu held.
+func (gn *GossipNode) enqueue(addr network.Address, size int, add func(*batch)) []batch {
+	due := make([]batch, 0)
+	if ob, exists := gn.outboxes[addr]; exists && ob.bytes+size > maxBatchBytes {
+		due = append(due, *gn.takeBatch(addr))
+	}
+
+	ob, exists := gn.outboxes[addr]
+	if !exists {
+		ob = &outbox{}
+		gn.outboxes[addr] = ob
+		ob.timer = time.AfterFunc(gn.batchInterval, func() { gn.flush(addr) })
+	}
+	add(&ob.batch)
+	ob.bytes += size
+
+	entries := len(ob.batch.Messages) + len(ob.batch.IHave) + len(ob.batch.Graft) + len(ob.batch.Other)
+	if entries >= gn.batchSize || ob.bytes >= maxBatchBytes {
+		due = append(due, *gn.takeBatch(addr))
+	}
+	return due
+}
+
+// takeBatch empties the outbox for addr. Must be called with gn.mu held.
+func (gn *GossipNode) takeBatch(addr network.Address) *batch {
+	ob, exists := gn.outboxes[addr]
+	if !exists {
+		return nil
+	}
+	ob.timer.Stop()
+	delete(gn.outboxes, addr)
+
+	b := ob.batch
+	return &b
+}
+
+// flush sends the batch for addr when its interval has passed
+func (gn *GossipNode) flush(addr network.Address) {
+	gn.mu.Lock()
+	b := gn.takeBatch(addr)
+	gn.mu.Unlock()
+
+	if b != nil {
+		gn.sendBatch(addr, *b)
+	}
+}
+
+// flushAll sends every pending batch, when the node shuts down
+func (gn *GossipNode) flushAll() {
+	gn.mu.Lock()
+	pending := make(map[network.Address]*batch, len(gn.outboxes))
+	for addr := range gn.outboxes {
+		pending[addr] = gn.takeBatch(addr)
+	}
+	gn.mu.Unlock()
+
+	for addr, b := range pending {
+		gn.sendBatch(addr, *b)
+	}
+}
+
+func (gn *GossipNode) sendBatch(addr network.Address, b batch) {
+	// data that does not fit in the reserve, next to the batch's own fields, is left off
+	if piggyback := gn.piggyback(); len(piggyback) <= piggybackReserve-256 {
+		b.Piggyback = piggyback
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		gn.Logger().WithError(err).Error("Failed to marshal batch")
+		return
+	}
+
+	if err := gn.node.Send(addr, "gossip-batch", data); err != nil {
+		gn.Logger().WithFields(log.Fields{"Peer": addr.String(), "Messages": len(b.Messages)}).WithError(err).Debug("Batch failed")
+		return
+	}
+
+	gn.mu.Lock()
+	gn.messagesSent += len(b.Messages)
+	gn.batchesSent++
+	gn.mu.Unlock()
+}
@@ -0,0 +1,248 @@
+package gossip
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+func TestBatchingHighRate(t *testing.T) {
+	for _, mode := range []BroadcastMode{GossipBroadcast, PlumtreeBroadcast} {
+		t.Run(mode.String(), func(t *testing.T) {
+			config := DefaultGossipConfig()
+			config.BroadcastMode = mode
+			config.BatchSize = 16
+			config.BatchInterval = 10 * time.Millisecond
+
+			net := network.NewMockNetwork()
+			builder := NewNetworkBuilder(net, config)
+			if err := builder.CreateNodes(5); err != nil {
+				t.Fatal(err)
+			}
+			nodes := builder.GetNodes()
+			for _, a := range nodes {
+				for _, b := range nodes {
+					a.AddPeer(b.Address())
+				}
+			}
+			builder.StartAllNodes()
+			defer builder.CloseAllNodes()
+
+			// far more rumors than the 100 slot queues hold when sent one by one
+			const rumors = 500
+			for i := 0; i < rumors; i++ {
+				if _, err := nodes[0].Gossip(fmt.Sprintf("rumor %d", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				done := true
+				for _, gn := range nodes[1:] {
+					if len(gn.GetReceivedMessages()) < rumors {
+						done = false
+					}
+				}
+				if done {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			for i, gn := range nodes[1:] {
+				if got := len(gn.GetReceivedMessages()); got != rumors {
+					t.Errorf("node %d received %d of %d rumors", i+1, got, rumors)
+				}
+			}
+			stats := nodes[0].Stats()
+			if stats.Batches == 0 || stats.Batches*4 > stats.Sent {
+				t.Errorf("expected rumors to travel in batches, sent %d in %d batches", stats.Sent, stats.Batches)
+			}
+		})
+	}
+}
+
+// recordingPiggybacker attaches fixed data and records what it receives
+type recordingPiggybacker struct {
+	mu       sync.Mutex
+	data     []byte
+	received [][]byte
+}
+
+func (p *recordingPiggybacker) Piggyback() []byte {
+	return p.data
+}
+
+func (p *recordingPiggybacker) ReceivePiggyback(data []byte, from network.Address) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.received = append(p.received, data)
+	return nil
+}
+
+func (p *recordingPiggybacker) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.received)
+}
+
+func TestInvalidPiggybackIsDropped(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	nodes[0].AddPeer(nodes[1].Address())
+	receiver := &recordingPiggybacker{}
+	nodes[0].SetPiggybacker(&recordingPiggybacker{data: []byte("not json")})
+	nodes[1].SetPiggybacker(receiver)
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// the rumor still goes out, without the data
+	nodes[0].Gossip("hello")
+	waitFor(t, func() bool { return len(nodes[1].GetReceivedMessages()) == 1 })
+	if receiver.count() != 0 {
+		t.Errorf("expected the invalid data to be dropped, got %s", receiver.received[0])
+	}
+}
+
+func TestBatchCarriesOtherProtocols(t *testing.T) {
+	config := DefaultGossipConfig()
+	config.BatchSize = 4
+	config.BatchInterval = 10 * time.Millisecond
+
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, config)
+	if err := builder.CreateNodes(3); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	nodes[0].AddPeer(nodes[1].Address())
+	nodes[0].AddPeer(nodes[2].Address())
+
+	var mu sync.Mutex
+	var got []string
+	nodes[1].Node().Handle("test-membership", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+		mu.Lock()
+		got = append(got, string(data))
+		mu.Unlock()
+		return nil
+	})
+	sender := &recordingPiggybacker{data: []byte(`{"update":1}`)}
+	receiver := &recordingPiggybacker{}
+	nodes[0].SetPiggybacker(sender)
+	nodes[1].SetPiggybacker(receiver)
+
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// fewer entries than the batch size go out when the interval passes
+	if err := nodes[0].Send(nodes[1].Address(), "test-membership", []byte("join")); err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].Gossip("hello")
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 1 && len(nodes[1].GetReceivedMessages()) == 1 && receiver.count() > 0
+	})
+
+	if got[0] != "join" {
+		t.Errorf("expected the batched message to reach its handler, got %q", got[0])
+	}
+	if nodes[0].Stats().Batches == 0 {
+		t.Errorf("expected the message to travel in a batch")
+	}
+	if string(receiver.received[0]) != `{"update":1}` {
+		t.Errorf("expected the piggybacked data to ride on the batch, got %s", receiver.received[0])
+	}
+	if peers := nodes[1].GetPeers(); len(peers) != 0 {
+		t.Errorf("expected batches to leave the peer list alone, node 1 has %v", peers)
+	}
+}
+
+func TestBatchedMessagesAreRateLimited(t *testing.T) {
+	config := DefaultGossipConfig()
+	config.BatchSize = 8
+	config.BatchInterval = 10 * time.Millisecond
+
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, config)
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+
+	var mu sync.Mutex
+	handled := 0
+	nodes[1].Node().Handle("test-membership", func(msg network.Message) error {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+		return nil
+	})
+	limiter := node.NewRateLimiter()
+	limiter.SetTypeLimit("test-membership", node.RateLimit{Rate: 0.1, Burst: 2})
+	nodes[1].Node().SetRateLimiter(limiter)
+
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// one batch, but five messages of a type limited to two
+	for i := 0; i < 5; i++ {
+		if err := nodes[0].Send(nodes[1].Address(), "test-membership", []byte("join")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadLetters := nodes[1].Node().DeadLetters()
+	waitFor(t, func() bool { return deadLetters.Count(node.ReasonRateLimited) == 3 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if handled != 2 {
+		t.Errorf("expected 2 messages within the limit to be handled, got %d", handled)
+	}
+	if nodes[0].Stats().Batches != 1 {
+		t.Errorf("expected the messages to travel in one batch, got %d batches", nodes[0].Stats().Batches)
+	}
+}
+
+func TestBatchesFitInDatagrams(t *testing.T) {
+	config := DefaultGossipConfig()
+	config.BatchSize = 16
+	config.BatchInterval = 10 * time.Millisecond
+
+	net := datagramNetwork{network.NewMockNetwork()}
+	builder := NewNetworkBuilder(net, config)
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	nodes[0].AddPeer(nodes[1].Address())
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// two of these rumors together do not fit in one datagram
+	const rumors = 10
+	for i := 0; i < rumors; i++ {
+		if _, err := nodes[0].Gossip(fmt.Sprintf("%d%s", i, strings.Repeat("x", 31000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, func() bool { return len(nodes[1].GetReceivedMessages()) == rumors })
+	if stats := nodes[0].Stats(); stats.Batches != rumors {
+		t.Errorf("expected every rumor in a batch of its own, sent %d in %d batches", stats.Sent, stats.Batches)
+	}
+}
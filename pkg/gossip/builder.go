@@ -3,58 +3,126 @@ package gossip
 import (
 	"fmt"
 	mathrand "math/rand"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/ncyborgse/go-template/pkg/hyparview"
 	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
 )
 
 // NetworkBuilder helps create a network of gossip nodes with random topology
 type NetworkBuilder struct {
 	network   network.Network
+	config    GossipConfig
 	nodes     []*GossipNode
+	nodeIndex map[node.NodeID]int  // node id to index in nodes
+	overlays  []*hyparview.Overlay // peer sampling, one per node, if BuildOverlay was used
 	traces    []MessageTrace
+	origins   map[string]origin // where and when each message was created
 	startTime time.Time
 	traceMu   sync.Mutex
+	logger    *log.Entry
 }
 
-func NewNetworkBuilder(net network.Network) *NetworkBuilder {
+// NewNetworkBuilder creates a builder whose nodes use config. Node i listens
+// on config.BindAddress with i added to the port.
+func NewNetworkBuilder(net network.Network, config GossipConfig) *NetworkBuilder {
 	return &NetworkBuilder{
 		network:   net,
+		config:    config,
 		nodes:     make([]*GossipNode, 0),
+		nodeIndex: make(map[node.NodeID]int),
 		traces:    make([]MessageTrace, 0),
+		origins:   make(map[string]origin),
 		startTime: time.Now(),
+		logger:    log.NewEntry(log.StandardLogger()),
+	}
+}
+
+// SetLogger replaces the logger of the builder and of all nodes it creates
+func (nb *NetworkBuilder) SetLogger(logger *log.Entry) {
+	nb.logger = logger
+	for _, node := range nb.nodes {
+		node.SetLogger(logger)
 	}
 }
 
 // CreateNodes creates the specified number of gossip nodes
 func (nb *NetworkBuilder) CreateNodes(count int) error {
-	fmt.Printf("creating %d gossip nodes...\n", count)
+	nb.logger.WithField("Count", count).Info("Creating gossip nodes")
 
 	for i := 0; i < count; i++ {
-		node, err := NewGossipNode(nb.network, i, 8000+i, nb)
+		config := nb.config
+		config.BindAddress.Port += len(nb.nodes)
+		if config.DataDir != "" {
+			config.DataDir = filepath.Join(config.DataDir, fmt.Sprintf("node-%d", len(nb.nodes)))
+		}
+		node, err := NewGossipNode(nb.network, len(nb.nodes), config, nb)
 		if err != nil {
 			return fmt.Errorf("failed to create node %d: %v", i, err)
 		}
+		node.SetLogger(nb.logger)
+		nb.nodeIndex[node.NodeID()] = len(nb.nodes)
 		nb.nodes = append(nb.nodes, node)
 	}
 
 	return nil
 }
 
+// indexOf returns the index of the node with the given id, or -1 if the
+// node was not created by this builder
+func (nb *NetworkBuilder) indexOf(id node.NodeID) int {
+	index, exists := nb.nodeIndex[id]
+	if !exists {
+		return -1
+	}
+	return index
+}
+
 // BuildRandomTopology creates random connections between nodes
 func (nb *NetworkBuilder) BuildRandomTopology(peerspernode int) {
-	fmt.Printf("building random topology (%d peers per node)...\n", peerspernode)
+	nb.logger.WithField("PeersPerNode", peerspernode).Info("Building random topology")
 
 	for _, node := range nb.nodes {
 		// randomly select peers for this node
 		selectedpeers := nb.SelectRandomPeers(node.id, peerspernode)
 
 		for _, peerid := range selectedpeers {
-			peeraddr := network.Address{IP: "127.0.0.1", Port: 8000 + peerid}
-			node.AddPeer(peeraddr)
+			node.AddPeer(nb.nodes[peerid].Address())
+		}
+	}
+}
+
+// BuildOverlay lets the nodes find their peers with a HyParView overlay
+// instead of a static topology, so the peer lists follow churn. Each node
+// joins through a random node created before it. The nodes must be started.
+func (nb *NetworkBuilder) BuildOverlay(config hyparview.Config) error {
+	nb.logger.WithField("ActiveSize", config.ActiveSize).Info("Building HyParView overlay")
+
+	for i, node := range nb.nodes {
+		overlay := hyparview.New(node.Node(), config)
+		overlay.SetSender(node)
+		overlay.Bind(node)
+		nb.overlays = append(nb.overlays, overlay)
+
+		if i > 0 {
+			contact := nb.nodes[mathrand.Intn(i)].Address()
+			if err := overlay.Join(contact); err != nil {
+				return fmt.Errorf("node %d failed to join the overlay: %v", i, err)
+			}
 		}
+		overlay.Start()
 	}
+
+	return nil
+}
+
+// GetOverlays returns the overlays created by BuildOverlay
+func (nb *NetworkBuilder) GetOverlays() []*hyparview.Overlay {
+	return nb.overlays
 }
 
 func (nb *NetworkBuilder) SelectRandomPeers(nodeid int, count int) []int {
@@ -88,7 +156,7 @@ func (nb *NetworkBuilder) SelectRandomPeers(nodeid int, count int) []int {
 
 // StartAllNodes starts all nodes in the network
 func (nb *NetworkBuilder) StartAllNodes() {
-	fmt.Printf("starting %d nodes...\n", len(nb.nodes))
+	nb.logger.WithField("Count", len(nb.nodes)).Info("Starting nodes")
 
 	for _, node := range nb.nodes {
 		node.Start()
@@ -98,15 +166,16 @@ func (nb *NetworkBuilder) StartAllNodes() {
 	time.Sleep(100 * time.Millisecond)
 }
 
-// InitiateGossip starts gossip from a random node
-func (nb *NetworkBuilder) InitiateGossip(content string) {
+// InitiateGossip starts gossip from a random node and returns the message id
+func (nb *NetworkBuilder) InitiateGossip(content string) string {
 	if len(nb.nodes) == 0 {
-		return
+		return ""
 	}
 
 	// pick a random node to start the gossip
 	starter := mathrand.Intn(len(nb.nodes))
-	nb.nodes[starter].Gossip(content)
+	msgid, _ := nb.nodes[starter].Gossip(content)
+	return msgid
 }
 
 // GetNodes returns all nodes in the network
@@ -116,6 +185,9 @@ func (nb *NetworkBuilder) GetNodes() []*GossipNode {
 
 // CloseAllNodes shuts down all nodes
 func (nb *NetworkBuilder) CloseAllNodes() {
+	for _, overlay := range nb.overlays {
+		overlay.Stop()
+	}
 	for _, node := range nb.nodes {
 		node.Close()
 	}
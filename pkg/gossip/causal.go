@@ -0,0 +1,175 @@
+package gossip
+
+import (
+	"time"
+)
+
+// With causal delivery on, every rumor carries the vector clock of its
+// origin at the time it was created. A node only delivers a rumor once it
+// has delivered everything the origin had delivered before creating it, and
+// buffers the rumor until then. Rumors are still forwarded right away, only
+// their delivery to the application waits.
+
+// DefaultCausalTimeout is how long a rumor waits for its dependencies
+// before it is delivered anyway
+const DefaultCausalTimeout = 5 * time.Second
+
+// VectorClock counts, per node id, the rumors from that node that were delivered
+type VectorClock map[string]uint64
+
+// Copy returns an independent copy of the clock
+func (vc VectorClock) Copy() VectorClock {
+	copied := make(VectorClock, len(vc))
+	for id, count := range vc {
+		copied[id] = count
+	}
+	return copied
+}
+
+// Merge raises every entry of the clock to the one in other
+func (vc VectorClock) Merge(other VectorClock) {
+	for id, count := range other {
+		if count > vc[id] {
+			vc[id] = count
+		}
+	}
+}
+
+// HappenedBefore reports whether vc causally precedes other
+func (vc VectorClock) HappenedBefore(other VectorClock) bool {
+	less := false
+	for id, count := range vc {
+		if count > other[id] {
+			return false
+		}
+		if count < other[id] {
+			less = true
+		}
+	}
+	for id, count := range other {
+		if _, exists := vc[id]; !exists && count > 0 {
+			less = true
+		}
+	}
+	return less
+}
+
+// Concurrent reports whether neither clock precedes the other
+func (vc VectorClock) Concurrent(other VectorClock) bool {
+	return !vc.HappenedBefore(other) && !other.HappenedBefore(vc)
+}
+
+// pendingMessage is a rumor waiting for its causal dependencies
+type pendingMessage struct {
+	msg     GossipMessage
+	arrived time.Time
+}
+
+// EnableCausalDelivery turns causal delivery on or off. All nodes of a
+// cluster should use the same setting; rumors without a clock are delivered
+// on arrival.
+func (gn *GossipNode) EnableCausalDelivery(enabled bool) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.causal = enabled
+}
+
+// SetCausalTimeout sets how long a rumor waits for missing dependencies
+// before it is delivered anyway, for example because they expired
+// elsewhere or the node joined after they were spread
+func (gn *GossipNode) SetCausalTimeout(timeout time.Duration) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.causalTimeout = timeout
+}
+
+// Clock returns the vector clock of the rumors delivered by this node
+func (gn *GossipNode) Clock() VectorClock {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return gn.clock.Copy()
+}
+
+// deliverable reports whether all causal dependencies of msg were
+// delivered. Must be called with gn.mu held.
+func (gn *GossipNode) deliverable(msg GossipMessage) bool {
+	origin := msg.Sender.String()
+	for id, count := range msg.Clock {
+		if id == origin {
+			if count != gn.clock[id]+1 {
+				return false
+			}
+		} else if count > gn.clock[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// deliverCausal records msg as delivered and queues it for the delivery
+// handlers. Must be called with gn.mu held.
+func (gn *GossipNode) deliverCausal(msg GossipMessage) {
+	gn.clock.Merge(msg.Clock)
+	gn.store(msg)
+	gn.deliveries = append(gn.deliveries, msg)
+}
+
+// deliverPending delivers every buffered rumor whose dependencies are met,
+// in causal order. Must be called with gn.mu held.
+func (gn *GossipNode) deliverPending() {
+	for progress := true; progress; {
+		progress = false
+		kept := gn.pending[:0]
+		for _, pending := range gn.pending {
+			if gn.deliverable(pending.msg) {
+				gn.deliverCausal(pending.msg)
+				progress = true
+			} else {
+				kept = append(kept, pending)
+			}
+		}
+		gn.pending = kept
+	}
+}
+
+// expirePending delivers rumors that waited longer than the causal timeout,
+// oldest first, together with whatever they unblock
+func (gn *GossipNode) expirePending() {
+	now := time.Now()
+
+	gn.mu.Lock()
+	for len(gn.pending) > 0 && now.Sub(gn.pending[0].arrived) > gn.causalTimeout {
+		late := gn.pending[0]
+		gn.pending = gn.pending[1:]
+		gn.Logger().WithField("MsgID", late.msg.ID).Warn("Delivering rumor without its causal dependencies")
+		gn.deliverCausal(late.msg)
+		gn.deliverPending()
+	}
+	gn.mu.Unlock()
+
+	gn.flushDeliveries()
+}
+
+// flushDeliveries runs the delivery handlers for queued rumors in queue
+// order. A call made while another one is running, including from a
+// handler, leaves the queue to that one.
+func (gn *GossipNode) flushDeliveries() {
+	gn.mu.Lock()
+	if gn.flushing {
+		gn.mu.Unlock()
+		return
+	}
+	gn.flushing = true
+
+	for len(gn.deliveries) > 0 {
+		msg := gn.deliveries[0]
+		gn.deliveries = gn.deliveries[1:]
+		gn.mu.Unlock()
+
+		gn.notifyDeliver(msg)
+
+		gn.mu.Lock()
+	}
+	gn.flushing = false
+	gn.mu.Unlock()
+}
@@ -0,0 +1,157 @@
+package gossip
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+func TestVectorClockOrder(t *testing.T) {
+	a := VectorClock{"x": 1}
+	b := VectorClock{"x": 1, "y": 1}
+	c := VectorClock{"x": 2}
+
+	if !a.HappenedBefore(b) || b.HappenedBefore(a) || a.HappenedBefore(a) {
+		t.Errorf("expected %v to precede %v only", a, b)
+	}
+	if !b.Concurrent(c) || a.Concurrent(c) {
+		t.Errorf("expected %v and %v to be concurrent", b, c)
+	}
+
+	b.Merge(c)
+	if b["x"] != 2 || b["y"] != 1 {
+		t.Errorf("expected merged clock x=2 y=1, got %v", b)
+	}
+}
+
+func TestCausalDeliveryBuffersReplies(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gn.Close()
+	gn.EnableCausalDelivery(true)
+
+	var delivered []string
+	gn.OnDeliver(func(msg GossipMessage) {
+		delivered = append(delivered, msg.Content)
+	})
+
+	alice, _ := node.NewRandomNodeID()
+	bob, _ := node.NewRandomNodeID()
+	question := GossipMessage{ID: "q", Content: "question", Sender: alice, Timestamp: time.Now(), TTL: 5,
+		Clock: VectorClock{alice.String(): 1}}
+	reply := GossipMessage{ID: "r", Content: "reply", Sender: bob, Timestamp: time.Now(), TTL: 5,
+		Clock: VectorClock{alice.String(): 1, bob.String(): 1}}
+
+	// the reply overtakes the question
+	gn.HandleGossipMessage(reply, bob)
+	if len(delivered) != 0 || gn.Stats().Pending != 1 {
+		t.Fatalf("expected the reply to wait for the question, delivered %v", delivered)
+	}
+
+	gn.HandleGossipMessage(question, alice)
+	if len(delivered) != 2 || delivered[0] != "question" || delivered[1] != "reply" {
+		t.Errorf("expected question then reply, got %v", delivered)
+	}
+	if received := gn.GetReceivedMessages(); len(received) != 2 || received[0].ID != "q" {
+		t.Errorf("expected received messages in causal order")
+	}
+	if clock := gn.Clock(); clock[alice.String()] != 1 || clock[bob.String()] != 1 {
+		t.Errorf("expected the clock to count both rumors, got %v", clock)
+	}
+}
+
+func TestCausalTimeoutDeliversOrphans(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gn.EnableCausalDelivery(true)
+	gn.SetCausalTimeout(50 * time.Millisecond)
+	gn.SetRoundInterval(10 * time.Millisecond)
+	gn.Start()
+	defer gn.Close()
+
+	// the first rumor from this origin never arrives
+	origin, _ := node.NewRandomNodeID()
+	orphan := GossipMessage{ID: "o", Content: "second", Sender: origin, Timestamp: time.Now(), TTL: 5,
+		Clock: VectorClock{origin.String(): 2}}
+	gn.HandleGossipMessage(orphan, origin)
+
+	deadline := time.Now().Add(time.Second)
+	for len(gn.GetReceivedMessages()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := gn.Stats(); stats.Pending != 0 || stats.Stored != 1 {
+		t.Errorf("expected the orphan to be delivered after the timeout, got %+v", stats)
+	}
+}
+
+func TestCausalConversation(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(20); err != nil {
+		t.Fatal(err)
+	}
+	builder.BuildRandomTopology(4)
+
+	nodes := builder.GetNodes()
+	var mu sync.Mutex
+	order := make(map[int][]string)
+	for _, gn := range nodes {
+		gn.EnableCausalDelivery(true)
+		gn.EnableAntiEntropy(true)
+
+		gn.OnDeliver(func(msg GossipMessage) {
+			mu.Lock()
+			order[gn.GetID()] = append(order[gn.GetID()], msg.Content)
+			mu.Unlock()
+
+			// every node answers the question as soon as it sees it
+			if msg.Content == "question" {
+				gn.Gossip("reply")
+			}
+		})
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// the asking node delivers the others' replies only, the others the
+	// question and all replies but their own
+	nodes[0].Gossip("question")
+	rumors := len(nodes) - 1
+
+	complete := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, gn := range nodes {
+			if len(order[gn.GetID()]) < rumors {
+				return false
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !complete() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, gn := range nodes {
+		delivered := order[gn.GetID()]
+		if len(delivered) != rumors {
+			t.Errorf("node %d delivered %d of %d rumors", gn.GetID(), len(delivered), rumors)
+			continue
+		}
+		if gn != nodes[0] && delivered[0] != "question" {
+			t.Errorf("node %d delivered a reply before the question: %v", gn.GetID(), delivered[:3])
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// DefaultMaxMessageSize keeps a rumor with its envelope within one UDP datagram
+const DefaultMaxMessageSize = 32 * 1024
+
+// DefaultCompressAbove gzips binary payloads that would take up a good part
+// of a datagram, such as replicated CRDT states
+const DefaultCompressAbove = 1024
+
+// maxDatagramPayload is the largest payload that fits in one UDP datagram of
+// 65507 bytes once the packet base64-encodes it, with 1 KiB left for the
+// packet's addresses and node id
+const maxDatagramPayload = (65507 - 1024) / 4 * 3
+
+// ErrMessageTooLarge is returned when gossiping content over the size limit
+var ErrMessageTooLarge = errors.New("gossip message too large")
+
+// GossipConfig contains the parameters of a gossip node
+type GossipConfig struct {
+	BindAddress    network.Address // NetworkBuilder adds the node index to the port
+	TTL            int             // hop count of created rumors
+	Fanout         int             // peers per push, <= 0 means all peers
+	InfectionMode  InfectionMode
+	StoppingRule   StoppingRule
+	StoppingK      int // useless pushes for feedback, inverse probability for the coin
+	BroadcastMode  BroadcastMode
+	GraftTimeout   time.Duration // Plumtree wait for announced messages
+	RoundInterval  time.Duration
+	Retention      time.Duration // how long message ids and messages are remembered
+	CacheCapacity  int           // message ids and messages remembered, <= 0 means no limit
+	MaxMessageSize int           // largest content or payload in bytes, compressed or not, <= 0 means no limit
+	CompressAbove  int           // gzip binary payloads larger than this many bytes, <= 0 means never
+	MaxPeers       int           // peer list limit, <= 0 means no limit
+	BatchSize      int           // messages coalesced per peer, <= 1 means no batching
+	BatchInterval  time.Duration // longest wait of a message in a batch
+	DataDir        string        // message log directory, empty keeps no log. NetworkBuilder adds node-<index>
+	AntiEntropy    bool
+	CausalDelivery bool
+}
+
+// DefaultGossipConfig returns the configuration nodes use unless told otherwise
+func DefaultGossipConfig() GossipConfig {
+	return GossipConfig{
+		BindAddress:    network.Address{IP: "127.0.0.1", Port: 8000},
+		TTL:            DefaultTTL,
+		InfectionMode:  InfectAndDie,
+		StoppingRule:   StopByTTL,
+		StoppingK:      2,
+		BroadcastMode:  GossipBroadcast,
+		GraftTimeout:   DefaultGraftTimeout,
+		RoundInterval:  DefaultRoundInterval,
+		Retention:      DefaultRetention,
+		CacheCapacity:  DefaultCacheCapacity,
+		MaxMessageSize: DefaultMaxMessageSize,
+		CompressAbove:  DefaultCompressAbove,
+		BatchSize:      1,
+		BatchInterval:  DefaultBatchInterval,
+	}
+}
+
+// Validate checks that the configuration can be used
+func (c GossipConfig) Validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative, got %d", c.TTL)
+	}
+	if c.StoppingRule != StopByTTL && c.StoppingK < 1 {
+		return fmt.Errorf("stopping rule %s needs k >= 1, got %d", c.StoppingRule, c.StoppingK)
+	}
+	if c.RoundInterval <= 0 {
+		return fmt.Errorf("round interval must be positive, got %v", c.RoundInterval)
+	}
+	if c.Retention <= 0 {
+		return fmt.Errorf("retention must be positive, got %v", c.Retention)
+	}
+	if c.BroadcastMode == PlumtreeBroadcast && c.GraftTimeout <= 0 {
+		return fmt.Errorf("graft timeout must be positive, got %v", c.GraftTimeout)
+	}
+	if c.BatchSize > 1 && c.BatchInterval <= 0 {
+		return fmt.Errorf("batch interval must be positive, got %v", c.BatchInterval)
+	}
+	if c.BindAddress.Port < 0 || c.BindAddress.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.BindAddress.Port)
+	}
+	return nil
+}
+
+// applyConfig sets the node's parameters. Must be called with gn.mu held.
+func (gn *GossipNode) applyConfig(c GossipConfig) {
+	gn.ttl = c.TTL
+	gn.fanout = c.Fanout
+	gn.infectionMode = c.InfectionMode
+	gn.stoppingRule = c.StoppingRule
+	gn.stoppingK = c.StoppingK
+	gn.broadcastMode = c.BroadcastMode
+	gn.graftTimeout = c.GraftTimeout
+	gn.roundInterval = c.RoundInterval
+	gn.seen.retention = c.Retention
+	gn.seen.capacity = c.CacheCapacity
+	gn.maxMessageSize = c.MaxMessageSize
+	gn.compressAbove = c.CompressAbove
+	gn.maxPeers = c.MaxPeers
+	gn.batchSize = c.BatchSize
+	gn.batchInterval = c.BatchInterval
+	gn.antiEntropy = c.AntiEntropy
+	gn.causal = c.CausalDelivery
+}
+
+// Config returns the node's current parameters, including changes made with setters
+func (gn *GossipNode) Config() GossipConfig {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	return GossipConfig{
+		BindAddress:    gn.addr,
+		TTL:            gn.ttl,
+		Fanout:         gn.fanout,
+		InfectionMode:  gn.infectionMode,
+		StoppingRule:   gn.stoppingRule,
+		StoppingK:      gn.stoppingK,
+		BroadcastMode:  gn.broadcastMode,
+		GraftTimeout:   gn.graftTimeout,
+		RoundInterval:  gn.roundInterval,
+		Retention:      gn.seen.retention,
+		CacheCapacity:  gn.seen.capacity,
+		MaxMessageSize: gn.maxMessageSize,
+		CompressAbove:  gn.compressAbove,
+		MaxPeers:       gn.maxPeers,
+		BatchSize:      gn.batchSize,
+		BatchInterval:  gn.batchInterval,
+		DataDir:        gn.dataDir,
+		AntiEntropy:    gn.antiEntropy,
+		CausalDelivery: gn.causal,
+	}
+}
+
+// tooLarge reports whether msg exceeds the size limit, before or after
+// decompression. Must be called with gn.mu held.
+func (gn *GossipNode) tooLarge(msg GossipMessage) bool {
+	if gn.maxMessageSize <= 0 {
+		return false
+	}
+	if msg.size() > gn.maxMessageSize {
+		return true
+	}
+	if msg.Encoding != EncodingGzip {
+		return false
+	}
+	_, err := decompress(msg.Payload, gn.maxMessageSize)
+	return err != nil
+}
+
+// pageBytes is how much a message that carries many others, such as an
+// anti-entropy digest or push, may hold: MaxMessageSize, or one datagram if
+// that is smaller or there is no limit. Must be called with gn.mu held.
+func (gn *GossipNode) pageBytes() int {
+	if gn.maxMessageSize > 0 && gn.maxMessageSize < maxDatagramPayload {
+		return gn.maxMessageSize
+	}
+	return maxDatagramPayload
+}
@@ -0,0 +1,52 @@
+package gossip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestGossipConfig(t *testing.T) {
+	config := DefaultGossipConfig()
+	config.BindAddress = network.Address{IP: "10.0.0.1", Port: 9000}
+	config.TTL = 5
+	config.Fanout = 3
+	config.BroadcastMode = PlumtreeBroadcast
+	config.RoundInterval = 20 * time.Millisecond
+	config.MaxMessageSize = 16
+
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, config)
+	if err := builder.CreateNodes(3); err != nil {
+		t.Fatal(err)
+	}
+	defer builder.CloseAllNodes()
+
+	gn := builder.GetNodes()[2]
+	if addr := gn.Address(); addr.IP != "10.0.0.1" || addr.Port != 9002 {
+		t.Errorf("expected node 2 to listen on 10.0.0.1:9002, got %s", addr.String())
+	}
+
+	expected := config
+	expected.BindAddress.Port = 9002
+	if applied := gn.Config(); applied != expected {
+		t.Errorf("expected the config to be applied, got %+v", applied)
+	}
+
+	if _, err := gn.Gossip(strings.Repeat("x", 17)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected oversized content to be rejected, got %v", err)
+	}
+	if _, err := gn.Gossip("small enough"); err != nil {
+		t.Errorf("expected small content to be accepted, got %v", err)
+	}
+
+	invalid := DefaultGossipConfig()
+	invalid.StoppingRule = StopByCoin
+	invalid.StoppingK = 0
+	if _, err := NewGossipNode(net, 3, invalid, nil); err == nil {
+		t.Errorf("expected an invalid config to be rejected")
+	}
+}
@@ -0,0 +1,137 @@
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxPeers is a peer list limit suited to nodes that bootstrap from
+// seeds, used by the CLI. The peer list is unlimited unless MaxPeers is set.
+const DefaultMaxPeers = 32
+
+// discoverSample is how many known peers are asked for their peer lists per bootstrap round
+const discoverSample = 3
+
+// SetMaxPeers limits the size of the peer list. Zero or less means unlimited.
+func (gn *GossipNode) SetMaxPeers(max int) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.maxPeers = max
+}
+
+func (gn *GossipNode) setupDiscovery() {
+	// send back our peer list, and remember the requester so seeds learn
+	// about the nodes that join through them
+	gn.node.Handle("discover", func(msg network.Message) error {
+		peerdata, err := json.Marshal(gn.GetPeers())
+		if err != nil {
+			return fmt.Errorf("failed to marshal peer list: %v", err)
+		}
+		gn.admitPeer(msg.From)
+		return gn.node.Send(msg.From, "peers", peerdata)
+	})
+
+	// merge the peer list we asked for
+	gn.node.Handle("peers", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var peers []network.Address
+		if err := json.Unmarshal(data, &peers); err != nil {
+			return fmt.Errorf("failed to unmarshal peer list: %v", err)
+		}
+
+		added := 0
+		if gn.addPeer(msg.From) {
+			added++
+		}
+		for _, peer := range peers {
+			if gn.addPeer(peer) {
+				added++
+			}
+		}
+
+		gn.Logger().WithFields(log.Fields{"From": msg.From.String(), "Offered": len(peers), "Added": added}).Debug("Merged peer list")
+		return nil
+	})
+}
+
+// admitPeer adds a node that asked us for peers. If the list is full a
+// random peer makes room, otherwise nodes that join late would be in
+// nobody's list and never receive a rumor.
+func (gn *GossipNode) admitPeer(peeraddr network.Address) {
+	if gn.addPeer(peeraddr) {
+		return
+	}
+
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	if peeraddr == gn.addr || len(gn.peers) == 0 {
+		return
+	}
+	for _, existing := range gn.peers {
+		if existing == peeraddr {
+			return
+		}
+	}
+	i := mathrand.Intn(len(gn.peers))
+	gn.forgetPeer(gn.peers[i])
+	gn.peers[i] = peeraddr
+}
+
+// Bootstrap joins the network through the seed nodes. It asks the seeds and
+// a few of the peers learned so far for their peer lists every round, until
+// the node knows want peers (or as many as its limit allows) or ctx is done.
+func (gn *GossipNode) Bootstrap(ctx context.Context, seeds []network.Address, want int) error {
+	gn.mu.RLock()
+	if gn.maxPeers > 0 && want > gn.maxPeers {
+		want = gn.maxPeers
+	}
+	interval := gn.roundInterval
+	gn.mu.RUnlock()
+
+	gn.Logger().WithFields(log.Fields{"Seeds": len(seeds), "Want": want}).Info("Bootstrapping")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		targets := append([]network.Address(nil), seeds...)
+		peers := gn.GetPeers()
+		mathrand.Shuffle(len(peers), func(i, j int) {
+			peers[i], peers[j] = peers[j], peers[i]
+		})
+		if len(peers) > discoverSample {
+			peers = peers[:discoverSample]
+		}
+		targets = append(targets, peers...)
+
+		for _, target := range targets {
+			if target == gn.addr {
+				continue
+			}
+			if err := gn.node.Send(target, "discover", nil); err != nil {
+				gn.Logger().WithField("Peer", target.String()).WithError(err).Debug("Discover failed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			found := len(gn.GetPeers())
+			return fmt.Errorf("bootstrap found %d of %d peers: %w", found, want, ctx.Err())
+		case <-ticker.C:
+		}
+
+		if found := len(gn.GetPeers()); found >= want {
+			gn.Logger().WithField("Peers", found).Info("Bootstrap complete")
+			return nil
+		}
+	}
+}
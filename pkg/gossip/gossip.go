@@ -5,57 +5,166 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/ncyborgse/go-template/pkg/network"
 	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
 )
 
+// maxConcurrentSends bounds the goroutines pushing messages to peers
+const maxConcurrentSends = 64
+
 // GossipMessage represents a piece of information spreading through the network
 type GossipMessage struct {
-	ID        string    `json:"id"`        // unique message identifier
-	Content   string    `json:"content"`   // the actual information
-	Sender    int       `json:"sender"`    // original sender node id
-	Timestamp time.Time `json:"timestamp"` // when message was created
-	TTL       int       `json:"ttl"`       // time-to-live (hops remaining)
+	ID          string          `json:"id"`                     // unique message identifier
+	Content     string          `json:"content"`                // the actual information, for text rumors
+	Payload     []byte          `json:"payload"`                // the actual information, for binary rumors, null for text
+	ContentType string          `json:"content_type,omitempty"` // media type of Payload, chosen by the application
+	Encoding    string          `json:"encoding,omitempty"`     // how Payload is compressed, empty if it is not
+	Sender      node.NodeID     `json:"sender"`                 // original sender node id
+	Timestamp   time.Time       `json:"timestamp"`              // when message was created
+	TTL         int             `json:"ttl"`                    // time-to-live (hops remaining)
+	Hops        int             `json:"hops"`                   // links travelled to reach the current holder
+	Clock       VectorClock     `json:"clock,omitempty"`        // origin's clock at creation, with causal delivery
+	Feedback    bool            `json:"feedback,omitempty"`     // the pusher wants to hear if the rumor was known
+	Piggyback   json.RawMessage `json:"piggyback,omitempty"`    // the pusher's Piggybacker data, not forwarded
 }
 
+// DeliverHandler is called once for every message delivered to this node
+type DeliverHandler func(msg GossipMessage)
+
 // GossipNode represents a node in the gossip network
 type GossipNode struct {
-	id           int
-	addr         network.Address
-	peers        []network.Address // known peer addresses
-	node         *node.Node
-	seenMessages map[string]bool // prevent message loops
-	receivedMsgs []GossipMessage // messages this node has received
-	mu           sync.RWMutex
+	id             int // index of the node, used as label in visualizations
+	addr           network.Address
+	peers          []network.Address // known peer addresses
+	maxPeers       int               // peer list limit, <= 0 means unlimited
+	maxMessageSize int               // content size limit, <= 0 means unlimited
+	compressAbove  int               // payload size above which payloads are gzipped, <= 0 means never
+	node           *node.Node
+	seen           *seenCache      // prevent message loops, bounded by retention and capacity
+	receivedMsgs   []GossipMessage // messages this node has received and still retains
+	mu             sync.RWMutex
 
 	// visualization tracking
 	builder *NetworkBuilder // reference to builder for trace logging
 
+	// delivery callbacks and subscriptions
+	deliverHandlers  []DeliverHandler
+	subscriptions    map[int]*Subscription
+	nextSubscription int
+
+	// another protocol's data on pushed rumors
+	piggybacker Piggybacker
+
+	// dissemination
+	fanout        int                      // peers per push, <= 0 means all peers
+	infectionMode InfectionMode            // how long rumors are spread
+	infective     map[string]GossipMessage // rumors still pushed every round
+	ttl           int                      // hop count of created rumors
+	stoppingRule  StoppingRule
+	stoppingK     int
+	useless       map[string]int // pushes of an infective rumor to nodes that knew it
+	roundInterval time.Duration
+	antiEntropy   bool          // exchange digests with a random peer every round
+	digestCursor  string        // start of the id range the next digest covers
+	stop          chan struct{} // closed when the node shuts down
+	stopOnce      sync.Once
+
+	// plumtree
+	broadcastMode BroadcastMode
+	graftTimeout  time.Duration
+	lazy          map[network.Address]bool   // peers that only get announcements
+	missing       map[string]*missingMessage // announced messages that have not arrived
+
+	// causal delivery
+	causal        bool
+	causalTimeout time.Duration
+	clock         VectorClock      // rumors delivered per origin
+	pending       []pendingMessage // rumors waiting for their dependencies, oldest first
+	deliveries    []GossipMessage  // delivered rumors waiting for the handlers
+	flushing      bool             // a goroutine is running the handlers for deliveries
+
+	// push-sum aggregation
+	aggregates       map[string]*aggregateState
+	aggregateSenders map[network.Address]time.Time // nodes that pushed shares here, and when they last did
+
+	sendSlots chan struct{} // one per running send goroutine
+
+	// batching
+	batchSize     int
+	batchInterval time.Duration
+	outboxes      map[network.Address]*outbox // pending batch per peer
+
+	// persistence
+	dataDir     string
+	wal         *writeAheadLog // nil without a data directory
+	catchUp     bool           // restarted, fetch what was missed from peers
+	catchUpWait int            // rounds until the catch-up digest is sent again
+
 	// statistics
-	messagesSent     int
-	messagesReceived int
+	messagesSent      int
+	batchesSent       int
+	messagesReceived  int
+	messagesExpired   int
+	messagesDuplicate int
 }
 
-// NewGossipNode creates a new gossip node
-func NewGossipNode(net network.Network, id int, port int, builder *NetworkBuilder) (*GossipNode, error) {
-	addr := network.Address{IP: "127.0.0.1", Port: port}
-	node, err := node.NewNode(net, addr)
+// NewGossipNode creates a new gossip node listening on config.BindAddress
+func NewGossipNode(net network.Network, id int, config GossipConfig, builder *NetworkBuilder) (*GossipNode, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config for gossip node %d: %v", id, err)
+	}
+
+	node, err := node.NewNode(net, config.BindAddress)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gossip node %d: %v", id, err)
 	}
 
+	return NewGossipNodeFromNode(node, id, config, builder)
+}
+
+// NewGossipNodeFromNode runs gossip on an existing node, for example one
+// created with a persistent id. config.BindAddress is not used.
+func NewGossipNodeFromNode(n *node.Node, id int, config GossipConfig, builder *NetworkBuilder) (*GossipNode, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config for gossip node %d: %v", id, err)
+	}
+
+	// ride out full receive queues instead of dropping the rumor
+	retrypolicy := node.DefaultRetryPolicy()
+	n.SetRetryPolicy(&retrypolicy)
+
 	gossipnode := &GossipNode{
-		id:           id,
-		addr:         addr,
-		peers:        make([]network.Address, 0),
-		node:         node,
-		seenMessages: make(map[string]bool),
-		receivedMsgs: make([]GossipMessage, 0),
-		builder:      builder,
+		id:               id,
+		addr:             n.Address(),
+		peers:            make([]network.Address, 0),
+		node:             n,
+		seen:             newSeenCache(DefaultRetention, DefaultCacheCapacity),
+		receivedMsgs:     make([]GossipMessage, 0),
+		builder:          builder,
+		infective:        make(map[string]GossipMessage),
+		useless:          make(map[string]int),
+		lazy:             make(map[network.Address]bool),
+		missing:          make(map[string]*missingMessage),
+		causalTimeout:    DefaultCausalTimeout,
+		clock:            make(VectorClock),
+		aggregates:       make(map[string]*aggregateState),
+		aggregateSenders: make(map[network.Address]time.Time),
+		subscriptions:    make(map[int]*Subscription),
+		outboxes:         make(map[network.Address]*outbox),
+		sendSlots:        make(chan struct{}, maxConcurrentSends),
+		stop:             make(chan struct{}),
+	}
+
+	gossipnode.applyConfig(config)
+
+	if config.DataDir != "" {
+		if err := gossipnode.recover(config.DataDir); err != nil {
+			return nil, fmt.Errorf("failed to recover gossip node %d: %v", id, err)
+		}
 	}
 
 	// set up message handlers
@@ -71,139 +180,353 @@ func (gn *GossipNode) SetupHandlers() {
 		if err := json.Unmarshal(msg.Payload[7:], &gossipmsg); err != nil { // skip "gossip:" prefix
 			return fmt.Errorf("failed to unmarshal gossip message: %v", err)
 		}
-
-		// Extract the immediate sender's node ID from the port
-		immediateForwarder := msg.From.Port - 8000
-		return gn.HandleGossipMessage(gossipmsg, immediateForwarder)
+		gossipmsg.Hops++
+		gn.receivePiggyback(gossipmsg.Piggyback, msg.From)
+		gossipmsg.Piggyback = nil
+
+		// The immediate sender's node id travels in the envelope
+		immediateForwarder, ok := node.SenderID(msg)
+		if !ok {
+			return fmt.Errorf("gossip message from %s has no sender id", msg.From.String())
+		}
+		gn.handlePushed(gossipmsg, immediateForwarder, msg.From)
+		return nil
 	})
 
+	// batches of messages and announcements
+	gn.setupBatching()
+
+	// rumor mongering feedback
+	gn.setupFeedback()
+
+	// broadcast trees
+	gn.setupPlumtree()
+
+	// repair missed messages
+	gn.setupAntiEntropy()
+
 	// handle peer discovery
-	gn.node.Handle("discover", func(msg network.Message) error {
-		// send back our peer list
-		peerdata, _ := json.Marshal(gn.peers)
-		return gn.node.Send(msg.From, "peers", peerdata)
-	})
+	gn.setupDiscovery()
+
+	// compute aggregates
+	gn.setupAggregation()
 }
 
-// AddPeer adds a peer to this node's peer list
+// AddPeer adds a peer to this node's peer list, unless the list is full
 func (gn *GossipNode) AddPeer(peeraddr network.Address) {
+	gn.addPeer(peeraddr)
+}
+
+// addPeer adds a peer and reports whether it was new and fit in the list
+func (gn *GossipNode) addPeer(peeraddr network.Address) bool {
 	gn.mu.Lock()
 	defer gn.mu.Unlock()
 
 	// don't add ourselves or duplicates
-	if peeraddr.Port == gn.addr.Port {
-		return
+	if peeraddr == gn.addr {
+		return false
 	}
 
 	for _, existing := range gn.peers {
-		if existing.Port == peeraddr.Port {
-			return // already exists
+		if existing == peeraddr {
+			return false // already exists
 		}
 	}
 
+	if gn.maxPeers > 0 && len(gn.peers) >= gn.maxPeers {
+		gn.Logger().WithFields(log.Fields{"Peer": peeraddr.String(), "MaxPeers": gn.maxPeers}).Debug("Peer list full, peer not added")
+		return false
+	}
+
 	gn.peers = append(gn.peers, peeraddr)
+	return true
+}
+
+// RemovePeer drops a peer, for example when membership declares it dead
+func (gn *GossipNode) RemovePeer(peeraddr network.Address) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	delete(gn.aggregateSenders, peeraddr)
+	for i, existing := range gn.peers {
+		if existing == peeraddr {
+			gn.peers = append(gn.peers[:i], gn.peers[i+1:]...)
+			gn.forgetPeer(peeraddr)
+			return
+		}
+	}
+}
+
+// forgetPeer drops the state kept for a peer that left the peer list. Must
+// be called with gn.mu held.
+func (gn *GossipNode) forgetPeer(peeraddr network.Address) {
+	delete(gn.lazy, peeraddr)
+	delete(gn.aggregateSenders, peeraddr)
+}
+
+// Node returns the underlying node, so other protocols such as membership
+// can share its address and connection
+func (gn *GossipNode) Node() *node.Node {
+	return gn.node
 }
 
 // Start begins the node's operation
 func (gn *GossipNode) Start() {
 	gn.node.Start()
+	go gn.runRounds()
 }
 
-// Gossip initiates spreading of a new message
-func (gn *GossipNode) Gossip(content string) error {
+// StartHealthProbes pings all peers every interval so their liveness can be
+// inspected with PeerHealth
+func (gn *GossipNode) StartHealthProbes(interval, timeout time.Duration) {
+	gn.node.StartProber(interval, timeout, gn.GetPeers)
+}
+
+// PeerHealth returns the liveness information collected by StartHealthProbes
+func (gn *GossipNode) PeerHealth() []node.PeerHealth {
+	return gn.node.HealthTable()
+}
+
+// GetPeers returns a copy of this node's peer list
+func (gn *GossipNode) GetPeers() []network.Address {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	peers := make([]network.Address, len(gn.peers))
+	copy(peers, gn.peers)
+	return peers
+}
+
+// Gossip initiates spreading of a new message and returns its id
+func (gn *GossipNode) Gossip(content string) (string, error) {
+	return gn.originate(GossipMessage{Content: content})
+}
+
+// originate stamps a new message created on this node and starts spreading
+// it. The message is stored for anti-entropy but not delivered locally.
+func (gn *GossipNode) originate(gossipmsg GossipMessage) (string, error) {
+	gn.mu.RLock()
+	tooLarge := gn.tooLarge(gossipmsg)
+	gn.mu.RUnlock()
+	if tooLarge {
+		return "", fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, gossipmsg.size())
+	}
+
 	// create unique message id
 	msgid := gn.GenerateMessageID()
+	gossipmsg.ID = msgid
+	gossipmsg.Sender = gn.node.ID()
+	gossipmsg.Timestamp = time.Now()
 
-	gossipmsg := GossipMessage{
-		ID:        msgid,
-		Content:   content,
-		Sender:    gn.id,
-		Timestamp: time.Now(),
-		TTL:       20, // maximum 20 hops
+	gn.Logger().WithFields(log.Fields{"MsgID": msgid, "Content": gossipmsg.summary()}).Info("Starting gossip")
+
+	// the originator has seen its own message, so it is not delivered
+	// when it comes back through the network
+	gn.mu.Lock()
+	gossipmsg.TTL = gn.ttl
+	if gn.causal {
+		gn.clock[gn.node.ID().String()]++
+		gossipmsg.Clock = gn.clock.Copy()
 	}
+	gn.store(gossipmsg)
+	gn.seen.add(msgid, gossipmsg.Timestamp)
+	plumtree := gn.broadcastMode == PlumtreeBroadcast
+	if !plumtree {
+		gossipmsg, _ = gn.infect(gossipmsg, true)
+	}
+	gn.mu.Unlock()
 
-	fmt.Printf("node %d starting gossip: '%s'\n", gn.id, content)
+	if gn.builder != nil {
+		gn.builder.recordOrigin(msgid, gn.id, gossipmsg.Timestamp)
+	}
 
-	return gn.SpreadGossip(gossipmsg)
+	if plumtree {
+		gn.plumtreeBroadcast(gossipmsg, network.Address{})
+		return msgid, nil
+	}
+	return msgid, gn.SpreadGossip(gossipmsg)
 }
 
-func (gn *GossipNode) HandleGossipMessage(msg GossipMessage, immediateForwarder int) error {
+// OnDeliver registers a handler that is called for every message delivered
+// to this node. Messages the node originates are not delivered to it.
+func (gn *GossipNode) OnDeliver(handler DeliverHandler) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.deliverHandlers = append(gn.deliverHandlers, handler)
+}
+
+// notifyDeliver runs the delivery handlers for msg
+func (gn *GossipNode) notifyDeliver(msg GossipMessage) {
+	gn.mu.RLock()
+	handlers := gn.deliverHandlers
+	gn.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	gn.notifySubscribers(msg)
+}
+
+func (gn *GossipNode) HandleGossipMessage(msg GossipMessage, immediateForwarder node.NodeID) error {
+	if !gn.receive(msg, immediateForwarder, "gossip") {
+		return nil // already processed
+	}
+
+	// decrease ttl and forward if still valid
+	gn.forward(msg)
+
+	return nil
+}
+
+// handlePushed processes a message that the peer at from pushed to us
+func (gn *GossipNode) handlePushed(msg GossipMessage, immediateForwarder node.NodeID, from network.Address) {
+	gn.mu.RLock()
+	plumtree := gn.broadcastMode == PlumtreeBroadcast
+	gn.mu.RUnlock()
+	if plumtree {
+		gn.plumtreeReceive(msg, immediateForwarder, from)
+		return
+	}
+
+	if !gn.receive(msg, immediateForwarder, "gossip") {
+		if msg.Feedback {
+			gn.sendFeedback(from, msg.ID)
+		}
+		return
+	}
+	gn.forward(msg)
+}
+
+// receive records and delivers a message unless it was seen before. It
+// reports whether the message was new.
+func (gn *GossipNode) receive(msg GossipMessage, immediateForwarder node.NodeID, msgType string) bool {
 	gn.mu.Lock()
 
 	// check if we've seen this message before
-	if gn.seenMessages[msg.ID] {
+	if gn.seen.contains(msg.ID) {
+		gn.messagesDuplicate++
 		gn.mu.Unlock()
-		return nil // already processed
+		gn.trace(msg, immediateForwarder, true)
+		return false
+	}
+
+	// its id may already have been forgotten, so it cannot be deduplicated
+	now := time.Now()
+	if gn.expired(msg, now) {
+		gn.messagesExpired++
+		gn.mu.Unlock()
+		return false
+	}
+
+	if gn.tooLarge(msg) {
+		gn.mu.Unlock()
+		gn.Logger().WithField("MsgID", msg.ID).Debug("Dropped oversized gossip message")
+		return false
 	}
 
 	// mark as seen
-	gn.seenMessages[msg.ID] = true
-	gn.receivedMsgs = append(gn.receivedMsgs, msg)
+	gn.seen.add(msg.ID, now)
 	gn.messagesReceived++
 
-	gn.mu.Unlock()
-
-	// Log message trace for visualization
-	if gn.builder != nil {
-		trace := MessageTrace{
-			Timestamp:          time.Now(),
-			MessageID:          msg.ID,
-			OriginalSender:     msg.Sender,
-			ImmediateForwarder: immediateForwarder,
-			Receiver:           gn.id,
-			Content:            msg.Content,
-			TTL:                msg.TTL,
-			IsDirect:           msg.Sender == immediateForwarder,
-		}
-		gn.builder.traceMu.Lock()
-		gn.builder.traces = append(gn.builder.traces, trace)
-		gn.builder.traceMu.Unlock()
+	causal := gn.causal && msg.Clock != nil
+	if causal {
+		// delivered once its dependencies are
+		gn.pending = append(gn.pending, pendingMessage{msg: msg, arrived: now})
+		gn.deliverPending()
+	} else {
+		gn.store(msg)
 	}
 
-	if msg.Sender == immediateForwarder {
-		// Direct from original sender
-		fmt.Printf("node %d received gossip from node %d: '%s'\n", gn.id, msg.Sender, msg.Content)
+	gn.mu.Unlock()
+
+	if causal {
+		gn.flushDeliveries()
 	} else {
-		// Forwarded by intermediate node
-		fmt.Printf("node %d received gossip from node %d (via node %d): '%s'\n", gn.id, msg.Sender, immediateForwarder, msg.Content)
+		gn.notifyDeliver(msg)
 	}
 
-	// decrease ttl and forward if still valid
-	if msg.TTL > 0 {
-		msg.TTL--
-		go gn.SpreadGossip(msg)
+	gn.trace(msg, immediateForwarder, false)
+
+	gn.Logger().WithFields(log.Fields{
+		"MsgID":   msg.ID,
+		"MsgType": msgType,
+		"Origin":  msg.Sender.Short(),
+		"Via":     immediateForwarder.Short(),
+		"Direct":  msg.Sender == immediateForwarder,
+		"TTL":     msg.TTL,
+		"Hops":    msg.Hops,
+	}).Debug("Received gossip")
+
+	return true
+}
+
+// trace logs a reception for visualization and metrics, if the node
+// belongs to a NetworkBuilder
+func (gn *GossipNode) trace(msg GossipMessage, immediateForwarder node.NodeID, duplicate bool) {
+	if gn.builder == nil {
+		return
 	}
 
-	return nil
+	trace := MessageTrace{
+		Timestamp:          time.Now(),
+		MessageID:          msg.ID,
+		OriginalSender:     gn.builder.indexOf(msg.Sender),
+		ImmediateForwarder: gn.builder.indexOf(immediateForwarder),
+		Receiver:           gn.id,
+		Content:            msg.summary(),
+		TTL:                msg.TTL,
+		Hops:               msg.Hops,
+		IsDirect:           msg.Sender == immediateForwarder,
+		IsDuplicate:        duplicate,
+	}
+	gn.builder.traceMu.Lock()
+	gn.builder.traces = append(gn.builder.traces, trace)
+	gn.builder.traceMu.Unlock()
 }
 
 func (gn *GossipNode) SpreadGossip(msg GossipMessage) error {
-	gn.mu.RLock()
-	peers := make([]network.Address, len(gn.peers))
-	copy(peers, gn.peers)
-	gn.mu.RUnlock()
+	peers := gn.selectTargets()
 
-	// send to all peers
+	// send to the selected peers
 	for _, peeraddr := range peers {
-		go func(addr network.Address) {
-			data, err := json.Marshal(msg)
-			if err != nil {
-				log.Printf("failed to marshal gossip message: %v", err)
-				return
-			}
+		gn.goSend(func() { gn.push(peeraddr, msg) })
+	}
+
+	return nil
+}
 
-			if err := gn.node.Send(addr, "gossip", data); err != nil {
-				// peer might be down or partitioned - that's ok in gossip protocols
-				return
-			}
+// goSend runs send on a goroutine of its own once fewer than
+// maxConcurrentSends are running, so bursts of rumors cannot spawn
+// goroutines without bound
+func (gn *GossipNode) goSend(send func()) {
+	gn.sendSlots <- struct{}{}
+	go func() {
+		defer func() { <-gn.sendSlots }()
+		send()
+	}()
+}
 
-			gn.mu.Lock()
-			gn.messagesSent++
-			gn.mu.Unlock()
-		}(peeraddr)
+// push sends a full message to a peer, or queues it for the next batch
+func (gn *GossipNode) push(addr network.Address, msg GossipMessage) {
+	if gn.queueMessage(addr, msg) {
+		return
 	}
 
-	return nil
+	msg.Piggyback = gn.piggyback()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		gn.Logger().WithField("MsgID", msg.ID).WithError(err).Error("Failed to marshal gossip message")
+		return
+	}
+
+	if err := gn.node.Send(addr, "gossip", data); err != nil {
+		// peer might be down or partitioned - that's ok in gossip protocols
+		return
+	}
+
+	gn.mu.Lock()
+	gn.messagesSent++
+	gn.mu.Unlock()
 }
 
 func (gn *GossipNode) GenerateMessageID() string {
@@ -212,30 +535,84 @@ func (gn *GossipNode) GenerateMessageID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// GetID returns the node's ID
+// Logger returns the structured logger used by this node
+func (gn *GossipNode) Logger() *log.Entry {
+	return gn.node.Logger()
+}
+
+// SetLogger replaces the logger of this node and its underlying node.Node
+func (gn *GossipNode) SetLogger(logger *log.Entry) {
+	gn.node.SetLogger(logger.WithField("Index", gn.id))
+}
+
+// GetID returns the node's index
 func (gn *GossipNode) GetID() int {
 	return gn.id
 }
 
+// NodeID returns the node's stable identity
+func (gn *GossipNode) NodeID() node.NodeID {
+	return gn.node.ID()
+}
+
+// Address returns the node's address
+func (gn *GossipNode) Address() network.Address {
+	return gn.addr
+}
+
 // GetStats returns node statistics
 func (gn *GossipNode) GetStats() (int, int, int, int) {
 	gn.mu.RLock()
 	defer gn.mu.RUnlock()
 
-	return len(gn.peers), len(gn.receivedMsgs), gn.messagesSent, gn.messagesReceived
+	return len(gn.peers), len(gn.received()), gn.messagesSent, gn.messagesReceived
 }
 
-// GetReceivedMessages returns all messages this node has received
+// GetReceivedMessages returns a copy of the messages this node received and
+// still retains, without the ones it originated. Use Subscribe to react to
+// new messages as they are delivered.
 func (gn *GossipNode) GetReceivedMessages() []GossipMessage {
 	gn.mu.RLock()
 	defer gn.mu.RUnlock()
 
-	messages := make([]GossipMessage, len(gn.receivedMsgs))
-	copy(messages, gn.receivedMsgs)
+	return gn.received()
+}
+
+// received returns the stored messages that came from other nodes. Must be
+// called with gn.mu held.
+func (gn *GossipNode) received() []GossipMessage {
+	self := gn.node.ID()
+	messages := make([]GossipMessage, 0, len(gn.receivedMsgs))
+	for _, msg := range gn.receivedMsgs {
+		if msg.Sender != self {
+			messages = append(messages, msg)
+		}
+	}
 	return messages
 }
 
+// hasSeen reports whether the node has delivered the message with the given id
+func (gn *GossipNode) hasSeen(msgID string) bool {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return gn.seen.contains(msgID)
+}
+
+// closed reports whether Close was called
+func (gn *GossipNode) closed() bool {
+	select {
+	case <-gn.stop:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close shuts down the node
 func (gn *GossipNode) Close() error {
+	gn.stopOnce.Do(func() { close(gn.stop) })
+	gn.flushAll()
+	gn.closeSubscriptions()
+	gn.closeLog()
 	return gn.node.Close()
 }
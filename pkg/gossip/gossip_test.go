@@ -1,17 +1,27 @@
 package gossip
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/ncyborgse/go-template/pkg/hyparview"
 	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
 )
 
 func TestGossipProtocol(t *testing.T) {
 	// Create network
 	net := network.NewMockNetwork()
-	builder := NewNetworkBuilder(net)
+
+	// With only 2 peers per node the first copy can arrive over a path
+	// longer than the default TTL, cutting off whatever lies behind it. A
+	// TTL of at least the network size lets flooding reach every node.
+	config := DefaultGossipConfig()
+	config.TTL = 100
+	builder := NewNetworkBuilder(net, config)
 
 	// Build network with 100 nodes, each knowing 2 random peers
 	err := builder.CreateNodes(100)
@@ -23,37 +33,37 @@ func TestGossipProtocol(t *testing.T) {
 	builder.StartAllNodes()
 
 	// Start gossip from random node
-	builder.InitiateGossip("Hello from the gossip network!")
+	msgid := builder.InitiateGossip("Hello from the gossip network!")
 
 	// Wait for propagation
-	time.Sleep(2 * time.Second)
+	metrics, err := builder.WaitForConvergence(msgid, 5*time.Second)
+	if err != nil {
+		t.Error(err)
+	}
 
 	// Analyze results
 	nodes := builder.GetNodes()
-	totalReached := 0
 	totalMessagesSent := 0
-
 	for _, node := range nodes {
-		peers, received, sent, _ := node.GetStats()
-		if received > 0 {
-			totalReached++
-		}
+		_, _, sent, _ := node.GetStats()
 		totalMessagesSent += sent
-
-		// Print sample of nodes that received the message
-		if totalReached <= 10 && received > 0 {
-			fmt.Printf("Node %d (peers: %d) received %d messages\n",
-				node.GetID(), peers, received)
-		}
 	}
 
-	fmt.Printf("\nGossip Results:")
-	fmt.Printf("- Network size: %d nodes\n", len(nodes))
-	fmt.Printf("- Nodes reached: %d (%.1f%%)\n",
-		totalReached, float64(totalReached)/float64(len(nodes))*100)
+	fmt.Printf("\nGossip Results:\n")
+	fmt.Printf("- Network size: %d nodes (%d reachable from the origin)\n", len(nodes), metrics.Reachable)
+	fmt.Printf("- Nodes reached: %d (%.1f%%)\n", metrics.Reached, metrics.Coverage*100)
+	fmt.Printf("- Time to 50/90/99/100%%: %v / %v / %v / %v\n",
+		metrics.TimeTo50, metrics.TimeTo90, metrics.TimeTo99, metrics.TimeTo100)
+	fmt.Printf("- Max hops: %d, redundant deliveries: %d, overhead: %.2f\n",
+		metrics.MaxHops, metrics.Redundant, metrics.Overhead)
 	fmt.Printf("- Total messages sent: %d\n", totalMessagesSent)
-	fmt.Printf("- Average messages per node: %.1f\n",
-		float64(totalMessagesSent)/float64(len(nodes)))
+
+	if metrics.Coverage != 1 {
+		t.Errorf("expected all %d reachable nodes to receive the message, reached %d", metrics.Reachable, metrics.Reached)
+	}
+	if metrics.Deliveries+1 != metrics.Reached {
+		t.Errorf("expected one first delivery per reached node besides the origin, got %d deliveries for %d nodes", metrics.Deliveries, metrics.Reached)
+	}
 
 	// Export visualization data
 	err = builder.ExportVisualizationData("./visualization")
@@ -63,3 +73,373 @@ func TestGossipProtocol(t *testing.T) {
 
 	builder.CloseAllNodes()
 }
+
+// countReached returns how many nodes have originated or received at least
+// one message
+func countReached(nodes []*GossipNode) int {
+	reached := 0
+	for _, node := range nodes {
+		if node.Stats().Stored > 0 {
+			reached++
+		}
+	}
+	return reached
+}
+
+func TestFanoutGossip(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(50); err != nil {
+		t.Fatal(err)
+	}
+	builder.BuildRandomTopology(8)
+
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		node.SetFanout(3)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	builder.InitiateGossip("fanout limited rumor")
+	time.Sleep(500 * time.Millisecond)
+
+	totalSent := 0
+	for _, node := range nodes {
+		_, _, sent, _ := node.GetStats()
+		totalSent += sent
+	}
+
+	// with infect-and-die every reached node pushes at most once to 3 peers
+	reached := countReached(nodes)
+	if totalSent > 3*reached {
+		t.Errorf("expected at most %d messages for %d reached nodes, got %d", 3*reached, reached, totalSent)
+	}
+}
+
+func TestInfectForever(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(10); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		for _, peer := range nodes {
+			node.AddPeer(peer.Address())
+		}
+		node.SetFanout(1)
+		node.SetInfectionMode(InfectForever)
+		node.SetRoundInterval(10 * time.Millisecond)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	builder.InitiateGossip("persistent rumor")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for countReached(nodes) < len(nodes) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reached := countReached(nodes); reached != len(nodes) {
+		t.Errorf("expected infect-forever to reach all %d nodes, reached %d", len(nodes), reached)
+	}
+}
+
+func TestAntiEntropyAfterHeal(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(10); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := builder.GetNodes()
+	for i, node := range nodes {
+		node.AddPeer(nodes[(i+1)%len(nodes)].Address())
+		node.AddPeer(nodes[(i+len(nodes)-1)%len(nodes)].Address())
+		node.SetRoundInterval(20 * time.Millisecond)
+		node.EnableAntiEntropy(true)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// the last node misses the rumor while it is cut off
+	isolated := nodes[len(nodes)-1]
+	net.Partition([]network.Address{isolated.Address()}, nil)
+	nodes[0].Gossip("sent during partition")
+	time.Sleep(200 * time.Millisecond)
+
+	if _, received, _, _ := isolated.GetStats(); received != 0 {
+		t.Fatalf("expected isolated node to miss the rumor")
+	}
+
+	net.Heal()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for countReached(nodes) < len(nodes) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reached := countReached(nodes); reached != len(nodes) {
+		t.Errorf("expected anti-entropy to reach all %d nodes after heal, reached %d", len(nodes), reached)
+	}
+}
+
+// datagramNetwork rejects messages that would not fit in a UDP datagram,
+// which the mock network does not check
+type datagramNetwork struct {
+	network.Network
+}
+
+func (n datagramNetwork) Dial(addr network.Address) (network.Connection, error) {
+	conn, err := n.Network.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return datagramConnection{conn}, nil
+}
+
+type datagramConnection struct {
+	network.Connection
+}
+
+func (c datagramConnection) Send(msg network.Message) error {
+	if base64.StdEncoding.EncodedLen(len(msg.Payload)) > 65507-1024 {
+		return network.ErrMessageTooLarge
+	}
+	return c.Connection.Send(msg)
+}
+
+func TestAntiEntropyFitsInDatagrams(t *testing.T) {
+	net := datagramNetwork{network.NewMockNetwork()}
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+
+	// each node holds far more messages than one digest or push can list
+	const held = 2000
+	sender, _ := node.NewRandomNodeID()
+	for i, gn := range nodes {
+		for j := 0; j < held; j++ {
+			gn.HandleGossipMessage(GossipMessage{ID: gn.GenerateMessageID(), Content: fmt.Sprintf("node %d message %d", i, j),
+				Sender: sender, Timestamp: time.Now(), TTL: 0}, sender)
+		}
+	}
+
+	for i, gn := range nodes {
+		gn.AddPeer(nodes[1-i].Address())
+		gn.SetRoundInterval(10 * time.Millisecond)
+		gn.EnableAntiEntropy(true)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && (nodes[0].Stats().Stored < 2*held || nodes[1].Stats().Stored < 2*held) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i, gn := range nodes {
+		if stored := gn.Stats().Stored; stored != 2*held {
+			t.Errorf("node %d holds %d of %d messages", i, stored, 2*held)
+		}
+	}
+}
+
+func TestGossipOverOverlayWithChurn(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(40); err != nil {
+		t.Fatal(err)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// probes that time out under load drop live peers, so leave them room
+	config := hyparview.DefaultConfig()
+	config.ShuffleInterval = 100 * time.Millisecond
+	config.ProbeTimeout = 50 * time.Millisecond
+	if err := builder.BuildOverlay(config); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	// crash every fifth node and give the overlay time to replace them
+	nodes := builder.GetNodes()
+	live := make([]*GossipNode, 0, len(nodes))
+	for i, node := range nodes {
+		if i%5 == 4 {
+			builder.GetOverlays()[i].Stop()
+			node.Close()
+			continue
+		}
+		live = append(live, node)
+	}
+	time.Sleep(time.Second)
+
+	live[0].Gossip("rumor after churn")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for countReached(live) < len(live) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reached := countReached(live); reached != len(live) {
+		t.Errorf("expected the rumor to reach all %d live nodes, reached %d", len(live), reached)
+	}
+}
+
+func TestBootstrapFromSeed(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(12); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		node.SetRoundInterval(20 * time.Millisecond)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// every node only knows the first one
+	bootstrapAll(t, nodes[1:], []network.Address{nodes[0].Address()}, 4)
+
+	nodes[len(nodes)-1].Gossip("hello bootstrapped network")
+	deadline := time.Now().Add(2 * time.Second)
+	for countReached(nodes) < len(nodes) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reached := countReached(nodes); reached != len(nodes) {
+		t.Errorf("expected the rumor to reach all %d nodes, reached %d", len(nodes), reached)
+	}
+}
+
+func TestBootstrapRespectsMaxPeers(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(12); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		node.SetRoundInterval(20 * time.Millisecond)
+		node.SetMaxPeers(3)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// asking for more peers than allowed settles for the limit
+	bootstrapAll(t, nodes[1:], []network.Address{nodes[0].Address()}, 10)
+
+	for _, node := range nodes {
+		if peers := len(node.GetPeers()); peers > 3 {
+			t.Errorf("node %d has %d peers, more than the limit of 3", node.GetID(), peers)
+		}
+	}
+}
+
+func TestAdmitPeerForgetsEvictedPeer(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gn.Close()
+	gn.SetMaxPeers(1)
+
+	evicted := network.Address{IP: "127.0.0.1", Port: 9001}
+	gn.AddPeer(evicted)
+	gn.mu.Lock()
+	gn.lazy[evicted] = true
+	gn.aggregateSenders[evicted] = time.Now()
+	gn.mu.Unlock()
+
+	// a full list makes room for a node that asks for peers
+	gn.admitPeer(network.Address{IP: "127.0.0.1", Port: 9002})
+
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	if containsAddress(gn.peers, evicted) {
+		t.Fatalf("expected the peer to be replaced, peers are %v", gn.peers)
+	}
+	if _, exists := gn.aggregateSenders[evicted]; exists || gn.lazy[evicted] {
+		t.Errorf("expected the state kept for the evicted peer to be dropped")
+	}
+}
+
+// bootstrapAll bootstraps the nodes concurrently and fails the test if any of them fails
+func bootstrapAll(t *testing.T, nodes []*GossipNode, seeds []network.Address, want int) {
+	errs := make(chan error, len(nodes))
+	for _, node := range nodes {
+		go func(gn *GossipNode) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			errs <- gn.Bootstrap(ctx, seeds, want)
+		}(node)
+	}
+	for range nodes {
+		if err := <-errs; err != nil {
+			t.Errorf("bootstrap failed: %v", err)
+		}
+	}
+}
+
+func TestBootstrapWithoutSeeds(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gn.SetRoundInterval(10 * time.Millisecond)
+	gn.Start()
+	defer gn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	unreachable := []network.Address{{IP: "127.0.0.1", Port: 9999}}
+	if err := gn.Bootstrap(ctx, unreachable, 1); err == nil {
+		t.Errorf("expected bootstrap through an unreachable seed to fail")
+	}
+}
+
+func TestWaitForConvergence(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(20); err != nil {
+		t.Fatal(err)
+	}
+
+	// a full mesh, so the TTL cannot stop the message early
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		for _, peer := range nodes {
+			node.AddPeer(peer.Address())
+		}
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	msgid := builder.InitiateGossip("everyone hears this")
+	metrics, err := builder.WaitForConvergence(msgid, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics.Reachable != len(nodes) || metrics.Reached != len(nodes) || metrics.Coverage != 1 {
+		t.Errorf("expected all %d nodes to be reached, got %+v", len(nodes), metrics)
+	}
+	if metrics.TimeTo50 > metrics.TimeTo90 || metrics.TimeTo90 > metrics.TimeTo100 || metrics.TimeTo100 == 0 {
+		t.Errorf("expected increasing coverage times, got %v / %v / %v", metrics.TimeTo50, metrics.TimeTo90, metrics.TimeTo100)
+	}
+	if metrics.HopCounts[1] == 0 {
+		t.Errorf("expected the origin's peers to receive the message after one hop, got %v", metrics.HopCounts)
+	}
+
+	// unknown messages have nothing to wait for
+	if _, err := builder.WaitForConvergence("unknown", 50*time.Millisecond); err == nil {
+		t.Errorf("expected waiting for an unknown message to fail")
+	}
+}
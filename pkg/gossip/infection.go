@@ -0,0 +1,96 @@
+package gossip
+
+import (
+	mathrand "math/rand"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// InfectionMode controls how long a node keeps spreading a rumor
+type InfectionMode int
+
+const (
+	// InfectAndDie forwards a rumor once, when it is first received
+	InfectAndDie InfectionMode = iota
+	// InfectForever forwards a rumor again every round until its TTL runs out
+	InfectForever
+)
+
+func (m InfectionMode) String() string {
+	switch m {
+	case InfectAndDie:
+		return "infect-and-die"
+	case InfectForever:
+		return "infect-forever"
+	default:
+		return "unknown"
+	}
+}
+
+// SetFanout sets how many random peers a rumor is pushed to per round.
+// Zero or less floods the rumor to all peers.
+func (gn *GossipNode) SetFanout(k int) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.fanout = k
+}
+
+// SetInfectionMode selects between infect-and-die and infect-forever
+func (gn *GossipNode) SetInfectionMode(mode InfectionMode) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.infectionMode = mode
+}
+
+// forward spreads a newly received rumor one more hop, if the stopping rule allows it
+func (gn *GossipNode) forward(msg GossipMessage) {
+	gn.mu.Lock()
+	msg, push := gn.infect(msg, false)
+	gn.mu.Unlock()
+
+	if push {
+		gn.SpreadGossip(msg)
+	}
+}
+
+// selectTargets picks the peers a rumor is pushed to in one round
+func (gn *GossipNode) selectTargets() []network.Address {
+	gn.mu.RLock()
+	peers := make([]network.Address, len(gn.peers))
+	copy(peers, gn.peers)
+	fanout := gn.fanout
+	gn.mu.RUnlock()
+
+	if fanout <= 0 || fanout >= len(peers) {
+		return peers
+	}
+
+	mathrand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	return peers[:fanout]
+}
+
+// pushInfective pushes every rumor that is still infective to another set of
+// peers. Under the TTL rule this uses up one unit of its TTL.
+func (gn *GossipNode) pushInfective() {
+	gn.mu.Lock()
+	push := make([]GossipMessage, 0, len(gn.infective))
+	for id, msg := range gn.infective {
+		if gn.stoppingRule == StopByTTL {
+			if msg.TTL <= 0 {
+				delete(gn.infective, id)
+				continue
+			}
+			msg.TTL--
+			gn.infective[id] = msg
+		}
+		push = append(push, msg)
+		gn.flipCoin(id)
+	}
+	gn.mu.Unlock()
+
+	for _, msg := range push {
+		gn.SpreadGossip(msg)
+	}
+}
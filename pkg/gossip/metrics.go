@@ -0,0 +1,190 @@
+package gossip
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// PropagationMetrics describes how one message spread through the network
+type PropagationMetrics struct {
+	MessageID string
+	Reached   int     // nodes that delivered the message, including the origin
+	Reachable int     // open nodes the origin can reach through peer lists
+	Coverage  float64 // Reached / Reachable
+	Residue   float64 // share of reachable nodes never reached, 1 - Coverage
+
+	// time from origination until the share of reachable nodes was reached,
+	// zero if it never was
+	TimeTo50  time.Duration
+	TimeTo90  time.Duration
+	TimeTo99  time.Duration
+	TimeTo100 time.Duration
+
+	HopCounts  map[int]int // first deliveries by hop count
+	MaxHops    int
+	Redundant  int     // receptions by nodes that had delivered the message already
+	Overhead   float64 // receptions per first delivery, 1 means no redundancy
+	Deliveries int     // first deliveries to nodes other than the origin
+	Traffic    int     // receptions of the message, first or redundant
+}
+
+// origin records where and when a message was created
+type origin struct {
+	index     int
+	timestamp time.Time
+}
+
+// recordOrigin remembers where a message was created, so metrics know
+// where to start
+func (nb *NetworkBuilder) recordOrigin(msgID string, index int, timestamp time.Time) {
+	nb.traceMu.Lock()
+	defer nb.traceMu.Unlock()
+	nb.origins[msgID] = origin{index: index, timestamp: timestamp}
+}
+
+// WaitForConvergence waits until the message has reached every node that
+// is reachable from its origin, or the timeout passes. It returns the
+// metrics either way, and an error on timeout.
+func (nb *NetworkBuilder) WaitForConvergence(msgID string, timeout time.Duration) (PropagationMetrics, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		reachable := nb.reachableFrom(msgID)
+		reached := 0
+		for index := range reachable {
+			if nb.nodes[index].hasSeen(msgID) {
+				reached++
+			}
+		}
+
+		if len(reachable) > 0 && reached == len(reachable) {
+			return nb.Metrics(msgID), nil
+		}
+		if time.Now().After(deadline) {
+			return nb.Metrics(msgID), fmt.Errorf("message %s reached %d of %d reachable nodes within %v", msgID, reached, len(reachable), timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Metrics computes the propagation metrics of a message from the traces
+func (nb *NetworkBuilder) Metrics(msgID string) PropagationMetrics {
+	nb.traceMu.Lock()
+	start, known := nb.origins[msgID]
+	traces := make([]MessageTrace, 0)
+	for _, trace := range nb.traces {
+		if trace.MessageID == msgID {
+			traces = append(traces, trace)
+		}
+	}
+	nb.traceMu.Unlock()
+
+	metrics := PropagationMetrics{
+		MessageID: msgID,
+		Reachable: len(nb.reachableFrom(msgID)),
+		HopCounts: make(map[int]int),
+	}
+	if !known {
+		return metrics
+	}
+
+	// the origin has the message from the start
+	arrivals := []time.Duration{0}
+	receptions := 0
+	for _, trace := range traces {
+		receptions++
+		if trace.IsDuplicate {
+			metrics.Redundant++
+			continue
+		}
+		metrics.Deliveries++
+		metrics.HopCounts[trace.Hops]++
+		if trace.Hops > metrics.MaxHops {
+			metrics.MaxHops = trace.Hops
+		}
+		arrivals = append(arrivals, trace.Timestamp.Sub(start.timestamp))
+	}
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i] < arrivals[j] })
+
+	metrics.Reached = len(arrivals)
+	if metrics.Reachable > 0 {
+		metrics.Coverage = float64(metrics.Reached) / float64(metrics.Reachable)
+		metrics.Residue = 1 - metrics.Coverage
+		metrics.TimeTo50 = timeToCoverage(arrivals, metrics.Reachable, 0.50)
+		metrics.TimeTo90 = timeToCoverage(arrivals, metrics.Reachable, 0.90)
+		metrics.TimeTo99 = timeToCoverage(arrivals, metrics.Reachable, 0.99)
+		metrics.TimeTo100 = timeToCoverage(arrivals, metrics.Reachable, 1.00)
+	}
+	metrics.Traffic = receptions
+	if metrics.Deliveries > 0 {
+		metrics.Overhead = float64(receptions) / float64(metrics.Deliveries)
+	}
+
+	return metrics
+}
+
+// timeToCoverage returns when the given share of reachable nodes had the
+// message, or zero if that never happened. arrivals must be sorted.
+func timeToCoverage(arrivals []time.Duration, reachable int, share float64) time.Duration {
+	needed := int(math.Ceil(share * float64(reachable)))
+	if needed < 1 {
+		needed = 1
+	}
+	if needed > len(arrivals) {
+		return 0
+	}
+	return arrivals[needed-1]
+}
+
+// reachableFrom returns the indices of the open nodes that the origin of
+// msgID can reach by following peer lists. A node running anti-entropy also
+// pulls from its peers, so for it the links work in both directions.
+func (nb *NetworkBuilder) reachableFrom(msgID string) map[int]bool {
+	nb.traceMu.Lock()
+	start, known := nb.origins[msgID]
+	nb.traceMu.Unlock()
+
+	reachable := make(map[int]bool)
+	if !known || nb.nodes[start.index].closed() {
+		return reachable
+	}
+
+	addrIndex := make(map[string]int, len(nb.nodes))
+	for i, node := range nb.nodes {
+		addrIndex[node.Address().String()] = i
+	}
+
+	links := make(map[int][]int, len(nb.nodes))
+	for i, node := range nb.nodes {
+		node.mu.RLock()
+		pulls := node.antiEntropy
+		node.mu.RUnlock()
+
+		for _, peer := range node.GetPeers() {
+			j, exists := addrIndex[peer.String()]
+			if !exists {
+				continue
+			}
+			links[i] = append(links[i], j)
+			if pulls {
+				links[j] = append(links[j], i)
+			}
+		}
+	}
+
+	reachable[start.index] = true
+	queue := []int{start.index}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range links[current] {
+			if !reachable[next] && !nb.nodes[next].closed() {
+				reachable[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return reachable
+}
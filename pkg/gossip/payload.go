@@ -0,0 +1,112 @@
+package gossip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// EncodingGzip marks a payload compressed with gzip
+const EncodingGzip = "gzip"
+
+// maxDecodedSize bounds what Data inflates a compressed payload to, so a
+// small message cannot expand without limit. Nodes drop messages that
+// inflate to more than their MaxMessageSize before they are delivered.
+const maxDecodedSize = 64 << 20
+
+// GossipBytes spreads a binary payload and returns its id. contentType tells
+// receivers how to interpret it, for example "application/x-protobuf". The
+// payload is gzipped if it is larger than the configured CompressAbove and
+// compression makes it smaller.
+func (gn *GossipNode) GossipBytes(payload []byte, contentType string) (string, error) {
+	gn.mu.RLock()
+	compressAbove := gn.compressAbove
+	gn.mu.RUnlock()
+
+	msg := GossipMessage{Payload: payload, ContentType: contentType}
+	if msg.Payload == nil {
+		msg.Payload = []byte{} // distinguishes an empty payload from a text rumor
+	}
+
+	if compressAbove > 0 && len(payload) > compressAbove {
+		compressed, err := compress(payload)
+		if err != nil {
+			return "", fmt.Errorf("failed to compress payload: %v", err)
+		}
+		if len(compressed) < len(payload) {
+			msg.Payload = compressed
+			msg.Encoding = EncodingGzip
+		}
+	}
+
+	return gn.originate(msg)
+}
+
+// Data returns the information carried by the message: the decoded payload
+// of a binary rumor, or the content of a text rumor
+func (msg GossipMessage) Data() ([]byte, error) {
+	if msg.Payload == nil {
+		return []byte(msg.Content), nil
+	}
+
+	switch msg.Encoding {
+	case "":
+		return msg.Payload, nil
+	case EncodingGzip:
+		return decompress(msg.Payload, maxDecodedSize)
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %q", msg.Encoding)
+	}
+}
+
+// IsBinary reports whether the message carries a payload rather than text
+func (msg GossipMessage) IsBinary() bool {
+	return msg.Payload != nil
+}
+
+// size returns the number of bytes of information the message carries
+func (msg GossipMessage) size() int {
+	return len(msg.Content) + len(msg.Payload)
+}
+
+// summary describes the message content for logs and traces
+func (msg GossipMessage) summary() string {
+	if !msg.IsBinary() {
+		return msg.Content
+	}
+	if msg.Encoding != "" {
+		return fmt.Sprintf("<%d bytes %s, %s>", len(msg.Payload), msg.ContentType, msg.Encoding)
+	}
+	return fmt.Sprintf("<%d bytes %s>", len(msg.Payload), msg.ContentType)
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress inflates a gzip payload, failing if it inflates to more than limit bytes
+func decompress(data []byte, limit int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gzip payload: %v", err)
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %v", err)
+	}
+	if len(decoded) > limit {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", limit)
+	}
+	return decoded, nil
+}
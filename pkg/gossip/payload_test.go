@@ -0,0 +1,114 @@
+package gossip
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestBinaryPayloads(t *testing.T) {
+	config := DefaultGossipConfig()
+	config.CompressAbove = 256
+	config.MaxMessageSize = 32768
+
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, config)
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	nodes[0].AddPeer(nodes[1].Address())
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	var mu sync.Mutex
+	received := make(map[string]GossipMessage)
+	nodes[1].OnDeliver(func(msg GossipMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[msg.ID] = msg
+	})
+
+	large := bytes.Repeat([]byte{0x08, 0x96, 0x01}, 10000)
+	small := []byte{0x00, 0xff}
+	largeID, err := nodes[0].GossipBytes(large, "application/x-protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallID, err := nodes[0].GossipBytes(small, "application/octet-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyID, err := nodes[0].GossipBytes(nil, "application/octet-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	textID, _ := nodes[0].Gossip("plain text")
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := len(received) == 4
+		mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	cases := []struct {
+		id, contentType, encoding string
+		data                      []byte
+		binary                    bool
+	}{
+		{largeID, "application/x-protobuf", EncodingGzip, large, true},
+		{smallID, "application/octet-stream", "", small, true},
+		{emptyID, "application/octet-stream", "", []byte{}, true},
+		{textID, "", "", []byte("plain text"), false},
+	}
+	for _, c := range cases {
+		msg, ok := received[c.id]
+		if !ok {
+			t.Errorf("message %s was not delivered", c.id)
+			continue
+		}
+		if msg.ContentType != c.contentType || msg.Encoding != c.encoding || msg.IsBinary() != c.binary {
+			t.Errorf("expected %q encoded %q, got %q encoded %q", c.contentType, c.encoding, msg.ContentType, msg.Encoding)
+		}
+		data, err := msg.Data()
+		if err != nil || !bytes.Equal(data, c.data) {
+			t.Errorf("expected the original %d bytes back, got %d bytes (%v)", len(c.data), len(data), err)
+		}
+	}
+	mu.Unlock()
+
+	// random data does not compress below the limit
+	noise := make([]byte, 40000)
+	rand.Read(noise)
+	if _, err := nodes[0].GossipBytes(noise, "application/octet-stream"); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected incompressible data over the limit to be rejected, got %v", err)
+	}
+
+	// compressing does not raise the limit, neither for this node nor for
+	// a message that inflates beyond it at the receiver
+	zeros := make([]byte, 40000)
+	if _, err := nodes[0].GossipBytes(zeros, "application/octet-stream"); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("expected data that inflates over the limit to be rejected, got %v", err)
+	}
+	compressed, _ := compress(zeros)
+	bomb := GossipMessage{ID: "bomb", Payload: compressed, Encoding: EncodingGzip, Sender: nodes[0].NodeID(), Timestamp: time.Now(), TTL: 5}
+	nodes[1].HandleGossipMessage(bomb, nodes[0].NodeID())
+	if nodes[1].hasSeen("bomb") {
+		t.Errorf("expected a payload that inflates over the limit to be dropped")
+	}
+
+	if _, err := (GossipMessage{Payload: small, Encoding: "br"}).Data(); err == nil {
+		t.Errorf("expected an unknown encoding to fail")
+	}
+}
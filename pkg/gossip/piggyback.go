@@ -0,0 +1,57 @@
+package gossip
+
+import (
+	"encoding/json"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// Piggybacker is another protocol, such as SWIM membership, that attaches
+// its own data to the rumors this node pushes instead of sending messages
+// of its own. The data travels one hop only; it is taken off a rumor before
+// the rumor is stored or forwarded.
+type Piggybacker interface {
+	// Piggyback returns the data for the next rumor pushed, nil if there is
+	// none. It must be JSON, anything else is dropped.
+	Piggyback() []byte
+	// ReceivePiggyback takes the data attached to a rumor from the peer at from
+	ReceivePiggyback(data []byte, from network.Address) error
+}
+
+// SetPiggybacker lets p attach data to the rumors this node pushes
+func (gn *GossipNode) SetPiggybacker(p Piggybacker) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.piggybacker = p
+}
+
+// piggyback returns the data to attach to the next rumor
+func (gn *GossipNode) piggyback() json.RawMessage {
+	gn.mu.RLock()
+	p := gn.piggybacker
+	gn.mu.RUnlock()
+
+	if p == nil {
+		return nil
+	}
+	data := p.Piggyback()
+	if len(data) > 0 && !json.Valid(data) {
+		gn.Logger().WithField("Bytes", len(data)).Warn("Dropped piggyback data that is not JSON")
+		return nil
+	}
+	return data
+}
+
+// receivePiggyback hands the data attached to a rumor to the piggybacker
+func (gn *GossipNode) receivePiggyback(data json.RawMessage, from network.Address) {
+	gn.mu.RLock()
+	p := gn.piggybacker
+	gn.mu.RUnlock()
+
+	if p == nil || len(data) == 0 {
+		return
+	}
+	if err := p.ReceivePiggyback(data, from); err != nil {
+		gn.Logger().WithField("From", from.String()).WithError(err).Debug("Dropped piggybacked data")
+	}
+}
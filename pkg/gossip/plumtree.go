@@ -0,0 +1,267 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// Plumtree (Leitão et al.) splits the peer list into eager and lazy peers.
+// New messages are pushed in full to eager peers and only announced by id
+// to lazy peers ("pt-ihave"). A node that receives a message twice prunes
+// the redundant link ("pt-prune"), moving it to the lazy peers on the other
+// side, so the eager links settle into a spanning tree. A node that hears
+// of a message through an announcement but does not receive it within the
+// graft timeout asks the announcer for it ("pt-graft"), which also turns
+// that link eager again and repairs the tree. Until the message arrives the
+// node keeps grafting its announcers in turn, up to maxGrafts times, since a
+// graft or the reply to it can be lost. All peers start out eager.
+
+// DefaultGraftTimeout is how long a node waits for an announced message
+// before it grafts the announcer
+const DefaultGraftTimeout = 250 * time.Millisecond
+
+// maxGrafts is how often a node grafts for one missing message before it
+// leaves the message to anti-entropy
+const maxGrafts = 8
+
+// BroadcastMode selects how messages are disseminated
+type BroadcastMode int
+
+const (
+	// GossipBroadcast pushes messages to selected peers according to the
+	// fanout, infection mode and stopping rule
+	GossipBroadcast BroadcastMode = iota
+	// PlumtreeBroadcast pushes messages along a lazily built spanning tree
+	// and announces them to the remaining peers
+	PlumtreeBroadcast
+)
+
+func (m BroadcastMode) String() string {
+	switch m {
+	case GossipBroadcast:
+		return "gossip"
+	case PlumtreeBroadcast:
+		return "plumtree"
+	default:
+		return "unknown"
+	}
+}
+
+// announcement lists the ids of messages a node has received
+type announcement struct {
+	IDs []string `json:"ids"`
+}
+
+// missingMessage is an announced message that has not arrived yet
+type missingMessage struct {
+	announcers []network.Address // next to graft first
+	grafts     int               // grafts sent so far
+	timer      *time.Timer
+}
+
+// SetBroadcastMode selects between gossip and Plumtree dissemination. All
+// nodes of a cluster should use the same mode.
+func (gn *GossipNode) SetBroadcastMode(mode BroadcastMode) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.broadcastMode = mode
+}
+
+// SetGraftTimeout sets how long an announced message may take to arrive
+func (gn *GossipNode) SetGraftTimeout(timeout time.Duration) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.graftTimeout = timeout
+}
+
+// EagerPeers returns the peers that Plumtree pushes full messages to
+func (gn *GossipNode) EagerPeers() []network.Address {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	eager := make([]network.Address, 0, len(gn.peers))
+	for _, peer := range gn.peers {
+		if !gn.lazy[peer] {
+			eager = append(eager, peer)
+		}
+	}
+	return eager
+}
+
+// LazyPeers returns the peers that Plumtree only announces messages to
+func (gn *GossipNode) LazyPeers() []network.Address {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	lazy := make([]network.Address, 0, len(gn.lazy))
+	for _, peer := range gn.peers {
+		if gn.lazy[peer] {
+			lazy = append(lazy, peer)
+		}
+	}
+	return lazy
+}
+
+// setupPlumtree registers the Plumtree control message handlers
+func (gn *GossipNode) setupPlumtree() {
+	gn.node.Handle("pt-ihave", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var ihave announcement
+		if err := json.Unmarshal(data, &ihave); err != nil {
+			return fmt.Errorf("failed to unmarshal announcement: %v", err)
+		}
+		gn.announced(ihave.IDs, msg.From)
+		return nil
+	})
+
+	gn.node.Handle("pt-graft", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var graft announcement
+		if err := json.Unmarshal(data, &graft); err != nil {
+			return fmt.Errorf("failed to unmarshal graft: %v", err)
+		}
+		gn.grafted(graft.IDs, msg.From)
+		return nil
+	})
+
+	gn.node.Handle("pt-prune", func(msg network.Message) error {
+		gn.mu.Lock()
+		defer gn.mu.Unlock()
+		gn.lazy[msg.From] = true
+		return nil
+	})
+}
+
+// announced waits for the messages a peer announced that we do not have yet
+func (gn *GossipNode) announced(ids []string, from network.Address) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	for _, id := range ids {
+		if gn.seen.contains(id) {
+			continue
+		}
+		missing, waiting := gn.missing[id]
+		if !waiting {
+			missing = &missingMessage{}
+			gn.missing[id] = missing
+			missing.timer = time.AfterFunc(gn.graftTimeout, func() { gn.graftMissing(id) })
+		}
+		if !containsAddress(missing.announcers, from) {
+			missing.announcers = append(missing.announcers, from)
+		}
+	}
+}
+
+// grafted makes the link to a peer eager again and sends it the messages it asked for
+func (gn *GossipNode) grafted(ids []string, from network.Address) {
+	gn.mu.Lock()
+	delete(gn.lazy, from)
+	gn.mu.Unlock()
+
+	for _, gossipmsg := range gn.messagesIn(ids) {
+		gn.push(from, gossipmsg)
+	}
+}
+
+// plumtreeReceive handles a message pushed by from
+func (gn *GossipNode) plumtreeReceive(msg GossipMessage, immediateForwarder node.NodeID, from network.Address) {
+	if !gn.receive(msg, immediateForwarder, "gossip") {
+		// a redundant link, keep it for announcements only
+		gn.mu.Lock()
+		gn.lazy[from] = true
+		gn.mu.Unlock()
+		if !gn.queuePrune(from) {
+			gn.node.Send(from, "pt-prune", nil)
+		}
+		return
+	}
+
+	gn.mu.Lock()
+	delete(gn.lazy, from)
+	if missing, waiting := gn.missing[msg.ID]; waiting {
+		missing.timer.Stop()
+		delete(gn.missing, msg.ID)
+	}
+	gn.mu.Unlock()
+
+	gn.plumtreeBroadcast(msg, from)
+}
+
+// plumtreeBroadcast pushes msg to the eager peers and announces it to the
+// lazy ones, skipping the peer it came from
+func (gn *GossipNode) plumtreeBroadcast(msg GossipMessage, from network.Address) {
+	gn.mu.RLock()
+	eager := make([]network.Address, 0, len(gn.peers))
+	lazy := make([]network.Address, 0, len(gn.lazy))
+	for _, peer := range gn.peers {
+		switch {
+		case peer == from:
+		case gn.lazy[peer]:
+			lazy = append(lazy, peer)
+		default:
+			eager = append(eager, peer)
+		}
+	}
+	gn.mu.RUnlock()
+
+	for _, peer := range eager {
+		gn.goSend(func() { gn.push(peer, msg) })
+	}
+
+	lazy = gn.queueAnnouncement(lazy, msg.ID)
+	if len(lazy) == 0 {
+		return
+	}
+	data, err := json.Marshal(announcement{IDs: []string{msg.ID}})
+	if err != nil {
+		gn.Logger().WithField("MsgID", msg.ID).WithError(err).Error("Failed to marshal announcement")
+		return
+	}
+	for _, peer := range lazy {
+		gn.goSend(func() { gn.node.Send(peer, "pt-ihave", data) })
+	}
+}
+
+// graftMissing asks the next announcer for a message that did not arrive
+// in time and makes the link to it eager. The announcer goes to the back of
+// the line in case this graft is lost too.
+func (gn *GossipNode) graftMissing(id string) {
+	gn.mu.Lock()
+	missing, waiting := gn.missing[id]
+	if !waiting || gn.seen.contains(id) || gn.closed() {
+		delete(gn.missing, id)
+		gn.mu.Unlock()
+		return
+	}
+
+	announcer := missing.announcers[0]
+	missing.announcers = append(missing.announcers[1:], announcer)
+	missing.grafts++
+	if missing.grafts < maxGrafts {
+		// the grafted peer answers right away, so the next wait is shorter
+		missing.timer = time.AfterFunc(gn.graftTimeout/2, func() { gn.graftMissing(id) })
+	} else {
+		delete(gn.missing, id)
+	}
+	delete(gn.lazy, announcer)
+	gn.mu.Unlock()
+
+	if gn.queueGraft(announcer, id) {
+		return
+	}
+	data, err := json.Marshal(announcement{IDs: []string{id}})
+	if err != nil {
+		return
+	}
+	if err := gn.node.Send(announcer, "pt-graft", data); err != nil {
+		gn.Logger().WithFields(log.Fields{"MsgID": id, "Peer": announcer.String()}).WithError(err).Debug("Graft failed")
+	}
+}
@@ -0,0 +1,124 @@
+package gossip
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func newPlumtreeNetwork(t *testing.T, size, peers int) *NetworkBuilder {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(size); err != nil {
+		t.Fatal(err)
+	}
+	builder.BuildRandomTopology(peers)
+
+	for _, node := range builder.GetNodes() {
+		node.SetBroadcastMode(PlumtreeBroadcast)
+		node.SetGraftTimeout(50 * time.Millisecond)
+	}
+	builder.StartAllNodes()
+	return builder
+}
+
+// broadcast sends a message from a node and waits until it reached every
+// node that can be reached
+func broadcast(t *testing.T, builder *NetworkBuilder, from int, content string) PropagationMetrics {
+	msgID, err := builder.GetNodes()[from].Gossip(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := builder.WaitForConvergence(msgID, 5*time.Second)
+	if err != nil {
+		t.Fatalf("%s: %v", content, err)
+	}
+	return metrics
+}
+
+func TestPlumtreeReducesRedundancy(t *testing.T) {
+	builder := newPlumtreeNetwork(t, 40, 5)
+	defer builder.CloseAllNodes()
+
+	// the first message floods the eager links and prunes the redundant ones
+	first := broadcast(t, builder, 0, "message 0")
+
+	var last PropagationMetrics
+	for i := 1; i <= 5; i++ {
+		last = broadcast(t, builder, 0, fmt.Sprintf("message %d", i))
+	}
+
+	t.Logf("redundant receptions: first %d, last %d", first.Redundant, last.Redundant)
+	if last.Redundant*2 > first.Redundant {
+		t.Errorf("expected the tree to cut redundancy, got %d then %d", first.Redundant, last.Redundant)
+	}
+
+	lazy := 0
+	for _, node := range builder.GetNodes() {
+		lazy += len(node.LazyPeers())
+	}
+	if lazy == 0 {
+		t.Errorf("expected some links to be pruned")
+	}
+}
+
+func TestPlumtreeRepairsTree(t *testing.T) {
+	builder := newPlumtreeNetwork(t, 30, 4)
+	defer builder.CloseAllNodes()
+
+	nodes := builder.GetNodes()
+	broadcast(t, builder, 0, "build the tree")
+
+	// take out the inner nodes of the tree, their subtrees must be grafted
+	// onto the rest through announcements
+	closed := 0
+	for _, node := range nodes[1:] {
+		if closed < 3 && len(node.EagerPeers()) > 1 {
+			node.Close()
+			closed++
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		metrics := broadcast(t, builder, 0, fmt.Sprintf("after failure %d", i))
+		if metrics.Coverage != 1 {
+			t.Errorf("expected full coverage, got %.2f", metrics.Coverage)
+		}
+	}
+}
+
+func TestPlumtreeRetriesLostGraft(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	for _, node := range nodes {
+		node.SetBroadcastMode(PlumtreeBroadcast)
+		node.SetGraftTimeout(20 * time.Millisecond)
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// node 1 holds a message that only its announcement tells node 0 about
+	msgID, err := nodes[1].Gossip("announced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	net.Partition([]network.Address{nodes[1].Address()}, nil)
+	nodes[0].announced([]string{msgID}, nodes[1].Address())
+
+	// the first graft is lost in the partition
+	waitFor(t, func() bool {
+		nodes[0].mu.RLock()
+		defer nodes[0].mu.RUnlock()
+		missing, waiting := nodes[0].missing[msgID]
+		return waiting && missing.grafts > 0
+	})
+	net.Heal()
+
+	waitFor(t, func() bool { return nodes[0].hasSeen(msgID) })
+}
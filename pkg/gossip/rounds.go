@@ -0,0 +1,40 @@
+package gossip
+
+import (
+	"time"
+)
+
+// DefaultRoundInterval is how often a node runs its periodic gossip work
+const DefaultRoundInterval = 100 * time.Millisecond
+
+// SetRoundInterval sets the length of a gossip round. It must be called before Start.
+func (gn *GossipNode) SetRoundInterval(interval time.Duration) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.roundInterval = interval
+}
+
+// runRounds does the node's periodic gossip work until the node is closed
+func (gn *GossipNode) runRounds() {
+	gn.mu.RLock()
+	interval := gn.roundInterval
+	gn.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gn.stop:
+			return
+		case <-ticker.C:
+			gn.pushInfective()
+			gn.antiEntropyRound()
+			gn.expireMessages()
+			gn.compactLog()
+			gn.catchUpRound()
+			gn.expirePending()
+			gn.aggregateRound()
+		}
+	}
+}
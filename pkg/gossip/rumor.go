@@ -0,0 +1,151 @@
+package gossip
+
+import (
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+// Rumor mongering after Demers et al.: with a feedback or coin stopping rule
+// a node keeps pushing a rumor every round (it is infective) until it loses
+// interest (it is removed), regardless of the hop count TTL. With feedback,
+// a node that receives a rumor it already knows answers "gossip-known", and
+// the pusher loses interest after k such answers. With the coin, the pusher
+// loses interest with probability 1/k after every push.
+
+// DefaultTTL is the hop count a rumor starts with
+const DefaultTTL = 20
+
+// StoppingRule decides when a node stops spreading a rumor
+type StoppingRule int
+
+const (
+	// StopByTTL forwards a rumor while its hop count TTL lasts, once or
+	// every round depending on the InfectionMode
+	StopByTTL StoppingRule = iota
+	// StopByFeedback stops after k pushes to peers that already knew the rumor
+	StopByFeedback
+	// StopByCoin stops with probability 1/k after every push
+	StopByCoin
+)
+
+func (r StoppingRule) String() string {
+	switch r {
+	case StopByTTL:
+		return "ttl"
+	case StopByFeedback:
+		return "feedback"
+	case StopByCoin:
+		return "coin"
+	default:
+		return "unknown"
+	}
+}
+
+// feedback tells a pusher that its rumor was already known
+type feedback struct {
+	ID string `json:"id"`
+}
+
+// SetTTL sets the hop count of the rumors this node creates
+func (gn *GossipNode) SetTTL(ttl int) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.ttl = ttl
+}
+
+// SetStoppingRule selects when the node stops spreading a rumor. k is the
+// number of useless pushes for StopByFeedback and the inverse probability
+// for StopByCoin; it is ignored for StopByTTL. With feedback or coin, the
+// InfectionMode and the TTL no longer limit spreading.
+func (gn *GossipNode) SetStoppingRule(rule StoppingRule, k int) error {
+	if rule != StopByTTL && k < 1 {
+		return fmt.Errorf("stopping rule %s needs k >= 1, got %d", rule, k)
+	}
+
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.stoppingRule = rule
+	gn.stoppingK = k
+	return nil
+}
+
+// setupFeedback registers the handler for rumor mongering feedback
+func (gn *GossipNode) setupFeedback() {
+	gn.node.Handle("gossip-known", func(msg network.Message) error {
+		_, data := node.ParsePayload(msg.Payload)
+
+		var known feedback
+		if err := json.Unmarshal(data, &known); err != nil {
+			return fmt.Errorf("failed to unmarshal feedback: %v", err)
+		}
+
+		gn.mu.Lock()
+		defer gn.mu.Unlock()
+		if _, infective := gn.infective[known.ID]; !infective || gn.stoppingRule != StopByFeedback {
+			return nil
+		}
+		gn.useless[known.ID]++
+		if gn.useless[known.ID] >= gn.stoppingK {
+			gn.loseInterest(known.ID)
+		}
+		return nil
+	})
+}
+
+// sendFeedback tells the node that pushed a known rumor to us
+func (gn *GossipNode) sendFeedback(to network.Address, msgID string) {
+	data, err := json.Marshal(feedback{ID: msgID})
+	if err != nil {
+		return
+	}
+	gn.node.Send(to, "gossip-known", data)
+}
+
+// infect prepares a rumor this node created (origin) or received for
+// spreading, and reports whether it should be pushed. Must be called with
+// gn.mu held.
+func (gn *GossipNode) infect(msg GossipMessage, origin bool) (GossipMessage, bool) {
+	if gn.stoppingRule != StopByTTL {
+		msg.Feedback = gn.stoppingRule == StopByFeedback
+		gn.infective[msg.ID] = msg
+		gn.flipCoin(msg.ID)
+		return msg, true
+	}
+
+	if !origin {
+		if msg.TTL <= 0 {
+			return msg, false
+		}
+		msg.TTL--
+	}
+	if gn.infectionMode == InfectForever {
+		// keep pushing the rumor in the following rounds
+		gn.infective[msg.ID] = msg
+	}
+	return msg, true
+}
+
+// flipCoin makes the node lose interest in a rumor it just pushed with
+// probability 1/k, under the coin rule. Must be called with gn.mu held.
+func (gn *GossipNode) flipCoin(msgID string) {
+	if gn.stoppingRule == StopByCoin && mathrand.Intn(gn.stoppingK) == 0 {
+		gn.loseInterest(msgID)
+	}
+}
+
+// loseInterest stops spreading a rumor. Must be called with gn.mu held.
+func (gn *GossipNode) loseInterest(msgID string) {
+	delete(gn.infective, msgID)
+	delete(gn.useless, msgID)
+}
+
+// infectiveCount returns the number of rumors the node still spreads
+func (gn *GossipNode) infectiveCount() int {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return len(gn.infective)
+}
@@ -0,0 +1,115 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// waitQuiet waits until no node has spread a rumor for a few rounds, so
+// pushes still in flight have arrived
+func waitQuiet(nodes []*GossipNode, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	var quietSince time.Time
+	for time.Now().Before(deadline) {
+		quiet := true
+		for _, node := range nodes {
+			if node.infectiveCount() > 0 {
+				quiet = false
+				break
+			}
+		}
+		switch {
+		case !quiet:
+			quietSince = time.Time{}
+		case quietSince.IsZero():
+			quietSince = time.Now()
+		case time.Since(quietSince) > 100*time.Millisecond:
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestFeedbackStopsRumor(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(2); err != nil {
+		t.Fatal(err)
+	}
+	nodes := builder.GetNodes()
+	nodes[0].AddPeer(nodes[1].Address())
+	nodes[1].AddPeer(nodes[0].Address())
+	for _, node := range nodes {
+		node.SetRoundInterval(10 * time.Millisecond)
+		if err := node.SetStoppingRule(StopByFeedback, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	msgID := builder.InitiateGossip("rumor")
+
+	// both nodes push to a node that knows the rumor until they heard it twice
+	if !waitQuiet(nodes, 2*time.Second) {
+		t.Fatalf("expected both nodes to lose interest in the rumor")
+	}
+	if !nodes[1].hasSeen(msgID) {
+		t.Errorf("expected the rumor to reach the second node")
+	}
+	if err := nodes[0].SetStoppingRule(StopByCoin, 0); err == nil {
+		t.Errorf("expected k = 0 to be rejected")
+	}
+}
+
+func TestRumorMongeringResidue(t *testing.T) {
+	rules := []struct {
+		rule StoppingRule
+		k    int
+	}{
+		{StopByFeedback, 2},
+		{StopByCoin, 3},
+	}
+
+	for _, r := range rules {
+		t.Run(r.rule.String(), func(t *testing.T) {
+			net := network.NewMockNetwork()
+			builder := NewNetworkBuilder(net, DefaultGossipConfig())
+			if err := builder.CreateNodes(50); err != nil {
+				t.Fatal(err)
+			}
+			builder.BuildRandomTopology(4)
+
+			nodes := builder.GetNodes()
+			for _, node := range nodes {
+				node.SetFanout(1)
+				node.SetRoundInterval(10 * time.Millisecond)
+				node.SetStoppingRule(r.rule, r.k)
+			}
+			builder.StartAllNodes()
+			defer builder.CloseAllNodes()
+
+			msgID := builder.InitiateGossip("rumor")
+			if !waitQuiet(nodes, 5*time.Second) {
+				t.Fatalf("expected every node to stop spreading the rumor")
+			}
+
+			metrics := builder.Metrics(msgID)
+			t.Logf("%s k=%d: residue %.2f, traffic %d", r.rule, r.k, metrics.Residue, metrics.Traffic)
+			// the coin may end an epidemic early, feedback keeps going until
+			// pushes stop being useful
+			if r.rule == StopByFeedback && metrics.Residue > 0.5 {
+				t.Errorf("expected most nodes to be reached, residue %.2f", metrics.Residue)
+			}
+			if metrics.Deliveries == 0 {
+				t.Errorf("expected the rumor to be pushed at least once")
+			}
+			if metrics.Traffic < metrics.Deliveries {
+				t.Errorf("expected traffic to include every delivery")
+			}
+		})
+	}
+}
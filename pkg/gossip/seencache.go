@@ -0,0 +1,182 @@
+package gossip
+
+import (
+	"container/list"
+	"time"
+)
+
+// Defaults for how long and how many messages a node remembers
+const (
+	DefaultRetention     = 10 * time.Minute
+	DefaultCacheCapacity = 10000
+)
+
+// rough per-entry overhead of the map, list element and slice bookkeeping,
+// used to estimate memory use in Stats
+const (
+	seenEntryOverhead   = 96
+	storedEntryOverhead = 128
+)
+
+// seenCache remembers message ids for a limited time and up to a limited
+// number of entries, evicting the oldest first
+type seenCache struct {
+	retention time.Duration
+	capacity  int
+	entries   map[string]*list.Element
+	order     *list.List // of seenEntry, oldest first
+	idBytes   int        // total length of the stored ids
+}
+
+type seenEntry struct {
+	id     string
+	seenAt time.Time
+}
+
+func newSeenCache(retention time.Duration, capacity int) *seenCache {
+	return &seenCache{
+		retention: retention,
+		capacity:  capacity,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// contains reports whether id was seen and not forgotten yet
+func (c *seenCache) contains(id string) bool {
+	_, exists := c.entries[id]
+	return exists
+}
+
+// add records id and reports whether it was new
+func (c *seenCache) add(id string, now time.Time) bool {
+	if c.contains(id) {
+		return false
+	}
+
+	c.entries[id] = c.order.PushBack(seenEntry{id: id, seenAt: now})
+	c.idBytes += len(id)
+
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		c.evict(c.order.Front())
+	}
+	return true
+}
+
+// expire forgets the ids seen before the retention window
+func (c *seenCache) expire(now time.Time) {
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		if now.Sub(front.Value.(seenEntry).seenAt) <= c.retention {
+			return
+		}
+		c.evict(front)
+	}
+}
+
+func (c *seenCache) evict(element *list.Element) {
+	entry := c.order.Remove(element).(seenEntry)
+	delete(c.entries, entry.id)
+	c.idBytes -= len(entry.id)
+}
+
+func (c *seenCache) len() int {
+	return c.order.Len()
+}
+
+// memoryBytes estimates the memory held by the cache
+func (c *seenCache) memoryBytes() int {
+	return c.idBytes + c.order.Len()*seenEntryOverhead
+}
+
+// Stats describes the state and memory use of a node
+type Stats struct {
+	Peers       int // size of the peer list
+	Stored      int // messages held for anti-entropy, originated ones included
+	Seen        int // message ids remembered for deduplication
+	Sent        int // gossip messages sent
+	Batches     int // batches sent, each holding several of the messages sent
+	Received    int // new messages received from other nodes
+	Duplicates  int // messages received again after they were delivered
+	Expired     int // messages dropped because they were older than the retention
+	Pending     int // rumors waiting for their causal dependencies
+	MemoryBytes int // estimated memory used by stored messages and seen ids
+}
+
+// SetRetention sets how long message ids and messages are remembered.
+// Messages whose Timestamp is older than the retention are dropped on
+// arrival, since their id may already have been forgotten.
+func (gn *GossipNode) SetRetention(retention time.Duration) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.seen.retention = retention
+}
+
+// SetCacheCapacity limits how many message ids and messages are remembered.
+// Zero or less means no limit besides the retention.
+func (gn *GossipNode) SetCacheCapacity(capacity int) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.seen.capacity = capacity
+}
+
+// expired reports whether msg is too old to be accepted. Must be called with gn.mu held.
+func (gn *GossipNode) expired(msg GossipMessage, now time.Time) bool {
+	return now.Sub(msg.Timestamp) > gn.seen.retention
+}
+
+// expireMessages forgets old message ids and drops stored messages that are
+// past the retention or over the capacity
+func (gn *GossipNode) expireMessages() {
+	now := time.Now()
+
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	gn.seen.expire(now)
+	for id, msg := range gn.infective {
+		if gn.expired(msg, now) {
+			gn.loseInterest(id)
+		}
+	}
+
+	kept := gn.receivedMsgs[:0]
+	for _, msg := range gn.receivedMsgs {
+		if !gn.expired(msg, now) {
+			kept = append(kept, msg)
+		}
+	}
+	if gn.seen.capacity > 0 && len(kept) > gn.seen.capacity {
+		// keep the newest, in place
+		n := copy(kept, kept[len(kept)-gn.seen.capacity:])
+		kept = kept[:n]
+	}
+	// clear the tail so dropped messages can be collected
+	for i := len(kept); i < len(gn.receivedMsgs); i++ {
+		gn.receivedMsgs[i] = GossipMessage{}
+	}
+	gn.receivedMsgs = kept
+}
+
+// Stats returns node statistics including an estimate of its memory use
+func (gn *GossipNode) Stats() Stats {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	memory := gn.seen.memoryBytes()
+	for _, msg := range gn.receivedMsgs {
+		memory += storedEntryOverhead + len(msg.ID) + msg.size()
+	}
+
+	return Stats{
+		Peers:       len(gn.peers),
+		Stored:      len(gn.receivedMsgs),
+		Seen:        gn.seen.len(),
+		Sent:        gn.messagesSent,
+		Batches:     gn.batchesSent,
+		Received:    gn.messagesReceived,
+		Duplicates:  gn.messagesDuplicate,
+		Expired:     gn.messagesExpired,
+		Pending:     len(gn.pending),
+		MemoryBytes: memory,
+	}
+}
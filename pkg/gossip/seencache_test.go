@@ -0,0 +1,78 @@
+package gossip
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+func TestSeenCacheBounds(t *testing.T) {
+	cache := newSeenCache(time.Minute, 3)
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if !cache.add(fmt.Sprintf("msg-%d", i), start.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("expected msg-%d to be new", i)
+		}
+	}
+	if cache.add("msg-4", start) {
+		t.Errorf("expected a duplicate add to report false")
+	}
+
+	// the capacity keeps only the newest three
+	if cache.len() != 3 || cache.contains("msg-0") || cache.contains("msg-1") || !cache.contains("msg-2") {
+		t.Errorf("expected msg-2 to msg-4 to remain, got %d entries", cache.len())
+	}
+
+	// msg-2 was seen at start+2s and is the only one past a minute just after start+62s
+	cache.expire(start.Add(62*time.Second + time.Millisecond))
+	if cache.contains("msg-2") || !cache.contains("msg-3") {
+		t.Errorf("expected only msg-2 to expire")
+	}
+
+	cache.expire(start.Add(time.Hour))
+	if cache.len() != 0 || cache.memoryBytes() != 0 {
+		t.Errorf("expected an empty cache, got %d entries using %d bytes", cache.len(), cache.memoryBytes())
+	}
+}
+
+func TestNodeForgetsExpiredMessages(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gn.SetRetention(100 * time.Millisecond)
+	gn.SetCacheCapacity(4)
+	gn.SetRoundInterval(10 * time.Millisecond)
+	gn.Start()
+	defer gn.Close()
+
+	for i := 0; i < 10; i++ {
+		gn.Gossip(fmt.Sprintf("rumor %d", i))
+	}
+
+	// the capacity applies right away, the retention within a few rounds
+	if stats := gn.Stats(); stats.Seen > 4 {
+		t.Errorf("expected at most 4 remembered ids, got %d", stats.Seen)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for gn.Stats().Stored > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := gn.Stats(); stats.Stored != 0 || stats.Seen != 0 || stats.MemoryBytes != 0 {
+		t.Errorf("expected everything to expire, got %+v", stats)
+	}
+
+	// a message older than the retention is dropped instead of delivered again
+	old := GossipMessage{ID: "old", Content: "stale", Timestamp: time.Now().Add(-time.Hour), TTL: 5}
+	forwarder, _ := node.NewRandomNodeID()
+	gn.HandleGossipMessage(old, forwarder)
+	if stats := gn.Stats(); stats.Expired != 1 || stats.Stored != 0 {
+		t.Errorf("expected the stale message to be dropped, got %+v", stats)
+	}
+}
@@ -0,0 +1,195 @@
+package gossip
+
+import (
+	"sync"
+)
+
+// DefaultSubscriptionBuffer is a reasonable buffer for Subscribe
+const DefaultSubscriptionBuffer = 64
+
+// SlowConsumerPolicy decides what happens to a delivered message when a
+// subscription's buffer is full
+type SlowConsumerPolicy int
+
+const (
+	// DropNewest drops the message that does not fit
+	DropNewest SlowConsumerPolicy = iota
+	// DropOldest drops the oldest buffered message to make room
+	DropOldest
+	// Block waits for the subscriber, which holds up delivery on the node
+	Block
+	// Disconnect closes the subscription
+	Disconnect
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// Subscription receives every message delivered to a node after it was
+// created, each exactly once unless the slow consumer policy drops it
+type Subscription struct {
+	node     *GossipNode
+	id       int
+	policy   SlowConsumerPolicy
+	ch       chan GossipMessage
+	done     chan struct{} // closed by Close, releases blocked deliveries
+	doneOnce sync.Once
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+// Subscribe returns a subscription to the messages delivered from now on.
+// buffer is the channel capacity. Without a buffer there is no oldest
+// message to drop, so DropOldest behaves like DropNewest.
+func (gn *GossipNode) Subscribe(buffer int, policy SlowConsumerPolicy) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+	if buffer == 0 && policy == DropOldest {
+		policy = DropNewest
+	}
+
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	sub := &Subscription{
+		node:   gn,
+		id:     gn.nextSubscription,
+		policy: policy,
+		ch:     make(chan GossipMessage, buffer),
+		done:   make(chan struct{}),
+	}
+	gn.nextSubscription++
+	gn.subscriptions[sub.id] = sub
+
+	// a closed node delivers nothing more
+	if gn.closed() {
+		delete(gn.subscriptions, sub.id)
+		sub.closeChannel()
+	}
+	return sub
+}
+
+// Messages returns the channel messages are delivered on. It is closed when
+// the subscription or the node is closed.
+func (s *Subscription) Messages() <-chan GossipMessage {
+	return s.ch
+}
+
+// Dropped returns the number of messages the slow consumer policy dropped
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close ends the subscription and closes its channel
+func (s *Subscription) Close() {
+	s.node.mu.Lock()
+	delete(s.node.subscriptions, s.id)
+	s.node.mu.Unlock()
+
+	s.closeChannel()
+}
+
+func (s *Subscription) closeChannel() {
+	// let a blocked delivery give up, it holds s.mu until then
+	s.doneOnce.Do(func() { close(s.done) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// deliver hands msg to the subscriber according to the policy
+func (s *Subscription) deliver(msg GossipMessage) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+		}
+		s.mu.Unlock()
+		return
+
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- msg:
+				s.mu.Unlock()
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped++
+			default:
+			}
+		}
+
+	default:
+		select {
+		case s.ch <- msg:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		s.dropped++
+		disconnect := s.policy == Disconnect
+		s.mu.Unlock()
+
+		if disconnect {
+			s.node.Logger().WithField("Subscription", s.id).Warn("Closing subscription of a slow consumer")
+			s.Close()
+		}
+	}
+}
+
+// notifySubscribers hands msg to every subscription
+func (gn *GossipNode) notifySubscribers(msg GossipMessage) {
+	gn.mu.RLock()
+	subs := make([]*Subscription, 0, len(gn.subscriptions))
+	for _, sub := range gn.subscriptions {
+		subs = append(subs, sub)
+	}
+	gn.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(msg)
+	}
+}
+
+// closeSubscriptions ends all subscriptions, when the node shuts down
+func (gn *GossipNode) closeSubscriptions() {
+	gn.mu.Lock()
+	subs := gn.subscriptions
+	gn.subscriptions = make(map[int]*Subscription)
+	gn.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.closeChannel()
+	}
+}
@@ -0,0 +1,164 @@
+package gossip
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+// drain reads everything buffered in a subscription without blocking
+func drain(sub *Subscription) []string {
+	contents := make([]string, 0)
+	for {
+		select {
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return contents
+			}
+			contents = append(contents, msg.Content)
+		default:
+			return contents
+		}
+	}
+}
+
+func TestSubscribeDeliversOnce(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := NewNetworkBuilder(net, DefaultGossipConfig())
+	if err := builder.CreateNodes(5); err != nil {
+		t.Fatal(err)
+	}
+	// a full mesh with anti-entropy receives every message several times
+	nodes := builder.GetNodes()
+	for _, a := range nodes {
+		a.EnableAntiEntropy(true)
+		a.SetRoundInterval(10 * time.Millisecond)
+		for _, b := range nodes {
+			a.AddPeer(b.Address())
+		}
+	}
+	sub := nodes[4].Subscribe(DefaultSubscriptionBuffer, DropNewest)
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	// node 4 does not deliver rumors it originates, so the others start them
+	for i := 0; i < 20; i++ {
+		nodes[i%4].Gossip(fmt.Sprintf("rumor %d", i))
+	}
+
+	seen := make(map[string]int)
+	timeout := time.After(2 * time.Second)
+	for len(seen) < 20 {
+		select {
+		case msg := <-sub.Messages():
+			seen[msg.ID]++
+		case <-timeout:
+			t.Fatalf("received %d of 20 messages", len(seen))
+		}
+	}
+
+	// anti-entropy keeps running, duplicates would show up now
+	time.Sleep(100 * time.Millisecond)
+	for _, content := range drain(sub) {
+		t.Errorf("unexpected extra delivery %q", content)
+	}
+	if sub.Dropped() != 0 {
+		t.Errorf("expected no drops, got %d", sub.Dropped())
+	}
+
+	sub.Close()
+	if _, ok := <-sub.Messages(); ok {
+		t.Errorf("expected the channel to be closed")
+	}
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newest := gn.Subscribe(2, DropNewest)
+	oldest := gn.Subscribe(2, DropOldest)
+	disconnect := gn.Subscribe(2, Disconnect)
+	block := gn.Subscribe(1, Block)
+
+	// a node without peers delivers the rumors it receives to its subscriptions
+	sender, _ := node.NewRandomNodeID()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			gn.HandleGossipMessage(GossipMessage{ID: fmt.Sprintf("m%d", i), Content: fmt.Sprintf("%d", i),
+				Sender: sender, Timestamp: time.Now(), TTL: 5}, sender)
+		}
+		close(done)
+	}()
+
+	blocked := make([]string, 0)
+	for len(blocked) < 5 {
+		select {
+		case msg := <-block.Messages():
+			blocked = append(blocked, msg.Content)
+		case <-time.After(time.Second):
+			t.Fatalf("blocking subscription received %v", blocked)
+		}
+	}
+	<-done
+
+	if got := drain(newest); fmt.Sprint(got) != "[0 1]" || newest.Dropped() != 3 {
+		t.Errorf("drop-newest kept %v and dropped %d", got, newest.Dropped())
+	}
+	if got := drain(oldest); fmt.Sprint(got) != "[3 4]" || oldest.Dropped() != 3 {
+		t.Errorf("drop-oldest kept %v and dropped %d", got, oldest.Dropped())
+	}
+	if got := drain(disconnect); fmt.Sprint(got) != "[0 1]" {
+		t.Errorf("disconnect kept %v", got)
+	}
+	if _, ok := <-disconnect.Messages(); ok {
+		t.Errorf("expected the slow subscription to be closed")
+	}
+	if fmt.Sprint(blocked) != "[0 1 2 3 4]" {
+		t.Errorf("expected the blocking subscription to get everything, got %v", blocked)
+	}
+
+	// closing the node ends the remaining subscriptions
+	gn.Close()
+	if _, ok := <-newest.Messages(); ok {
+		t.Errorf("expected subscriptions to be closed with the node")
+	}
+}
+
+func TestDropOldestWithoutBuffer(t *testing.T) {
+	net := network.NewMockNetwork()
+	gn, err := NewGossipNode(net, 0, DefaultGossipConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gn.Close()
+
+	sub := gn.Subscribe(0, DropOldest)
+	sender, _ := node.NewRandomNodeID()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			gn.HandleGossipMessage(GossipMessage{ID: fmt.Sprintf("m%d", i), Content: fmt.Sprintf("%d", i),
+				Sender: sender, Timestamp: time.Now(), TTL: 5}, sender)
+		}
+		close(done)
+	}()
+
+	// nobody reads, the messages are dropped instead of spinning forever
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("delivery to an unbuffered subscription did not return")
+	}
+	if sub.Dropped() != 3 {
+		t.Errorf("expected 3 drops, got %d", sub.Dropped())
+	}
+	sub.Close()
+}
@@ -7,6 +7,9 @@ import (
 	mathrand "math/rand"
 	"os"
 	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	log "github.com/sirupsen/logrus"
 )
 
 // NetworkTopology represents the network structure for visualization
@@ -50,7 +53,9 @@ type MessageTrace struct {
 	Receiver           int       `json:"receiver"`
 	Content            string    `json:"content"`
 	TTL                int       `json:"ttl"`
+	Hops               int       `json:"hops"`
 	IsDirect           bool      `json:"isDirect"`
+	IsDuplicate        bool      `json:"isDuplicate"` // the receiver had seen the message already
 }
 
 // VisualizationData contains all data needed for visualization
@@ -76,10 +81,16 @@ func (nb *NetworkBuilder) ExportVisualizationData(outputDir string) error {
 	// Generate network topology
 	topology := nb.generateTopology()
 
+	// nodes may still be tracing duplicates, export a snapshot
+	nb.traceMu.Lock()
+	traces := make([]MessageTrace, len(nb.traces))
+	copy(traces, nb.traces)
+	nb.traceMu.Unlock()
+
 	// Create visualization data
 	visData := VisualizationData{
 		Topology:  topology,
-		Traces:    nb.traces,
+		Traces:    traces,
 		StartTime: nb.startTime,
 	}
 
@@ -95,8 +106,11 @@ func (nb *NetworkBuilder) ExportVisualizationData(outputDir string) error {
 		return fmt.Errorf("failed to write visualization file: %v", err)
 	}
 
-	fmt.Printf("Exported visualization data to %s\n", filename)
-	fmt.Printf("Total nodes: %d, Total message traces: %d\n", len(topology.Nodes), len(nb.traces))
+	nb.logger.WithFields(log.Fields{
+		"File":   filename,
+		"Nodes":  len(topology.Nodes),
+		"Traces": len(traces),
+	}).Info("Exported visualization data")
 	return nil
 }
 
@@ -111,12 +125,18 @@ func (nb *NetworkBuilder) generateTopology() NetworkTopology {
 		nodeConnections[i] = make([]int, 0)
 	}
 
+	// Map addresses back to node indices
+	addrIndex := make(map[network.Address]int, len(nb.nodes))
+	for i, node := range nb.nodes {
+		addrIndex[node.addr] = i
+	}
+
 	// Build edges and bidirectional connections
 	for _, node := range nb.nodes {
 		node.mu.RLock()
 		for _, peerAddr := range node.peers {
-			peerID := peerAddr.Port - 8000
-			if peerID >= 0 && peerID < len(nb.nodes) {
+			peerID, known := addrIndex[peerAddr]
+			if known {
 				// Add edge for visualization
 				edges = append(edges, EdgeInfo{
 					From: node.GetID(),
@@ -0,0 +1,321 @@
+package gossip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	log "github.com/sirupsen/logrus"
+)
+
+// With a data directory, every message a node delivers is appended to a
+// write-ahead log there. Each record is a JSON encoded GossipMessage framed by
+// its length and CRC-32 checksum. A restarted node replays the log to rebuild
+// its stored messages, seen ids and vector clock, without delivering the
+// messages again, and then sends its digest pages to a few peers to fetch
+// what it missed while it was down. It sends them again every few rounds
+// until a peer answers the last page. Messages that even the peers missed
+// are only fetched by anti-entropy, which must be enabled for that. A torn or corrupt record at the end of the log,
+// as left by a crash during a write, is cut off. Once most records belong to
+// messages that expired, the log is compacted by rewriting the stored
+// messages to a new file. Messages that wait for causal dependencies are not
+// logged; anti-entropy fetches them again.
+
+// walFile is the name of the log in the data directory
+const walFile = "gossip.wal"
+
+// walHeaderSize is the length and checksum in front of every record
+const walHeaderSize = 8
+
+// maxRecordSize guards against allocating for a corrupt length
+const maxRecordSize = 64 << 20
+
+// compactMinRecords is the log size below which compaction is not worth it
+const compactMinRecords = 1000
+
+// catchUpPeers is how many peers a restarted node exchanges digests with
+const catchUpPeers = 3
+
+// catchUpRetryRounds is how many rounds a restarted node waits for an
+// answer before it sends its digest again
+const catchUpRetryRounds = 10
+
+// writeAheadLog is an append-only file of delivered messages
+type writeAheadLog struct {
+	path    string
+	file    *os.File
+	records int // records in the file, live or expired
+}
+
+// openLog opens the log in dir, creating both if needed, and returns the
+// messages it holds. existed reports whether there was a log to replay.
+func openLog(dir string) (wal *writeAheadLog, messages []GossipMessage, existed bool, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	path := filepath.Join(dir, walFile)
+	_, err = os.Stat(path)
+	existed = err == nil
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to open log: %v", err)
+	}
+
+	messages, valid, err := readRecords(file)
+	if err != nil {
+		log.WithFields(log.Fields{"Path": path, "Records": len(messages)}).WithError(err).Warn("Truncating damaged log")
+	}
+	// drop a damaged tail and append after the last good record
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, nil, false, fmt.Errorf("failed to truncate log: %v", err)
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, false, fmt.Errorf("failed to seek log: %v", err)
+	}
+
+	return &writeAheadLog{path: path, file: file, records: len(messages)}, messages, existed, nil
+}
+
+// readRecords reads records until the end of r and returns them with the
+// length of the valid prefix. The error describes a damaged record, if any.
+func readRecords(r io.Reader) ([]GossipMessage, int64, error) {
+	reader := bufio.NewReader(r)
+	messages := make([]GossipMessage, 0)
+	var valid int64
+
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return messages, valid, nil
+			}
+			return messages, valid, fmt.Errorf("incomplete record header at offset %d", valid)
+		}
+
+		length := binary.BigEndian.Uint32(header[:4])
+		checksum := binary.BigEndian.Uint32(header[4:])
+		if length > maxRecordSize {
+			return messages, valid, fmt.Errorf("record at offset %d claims %d bytes", valid, length)
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return messages, valid, fmt.Errorf("incomplete record at offset %d", valid)
+		}
+		if crc32.ChecksumIEEE(data) != checksum {
+			return messages, valid, fmt.Errorf("checksum mismatch at offset %d", valid)
+		}
+
+		var msg GossipMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return messages, valid, fmt.Errorf("failed to unmarshal record at offset %d: %v", valid, err)
+		}
+		messages = append(messages, msg)
+		valid += walHeaderSize + int64(length)
+	}
+}
+
+// writeRecord frames msg and writes it to w
+func writeRecord(w io.Writer, msg GossipMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %v", err)
+	}
+
+	// one write per record, so a crash tears at most the last one
+	record := make([]byte, walHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:walHeaderSize], crc32.ChecksumIEEE(data))
+	copy(record[walHeaderSize:], data)
+
+	_, err = w.Write(record)
+	return err
+}
+
+// append adds a delivered message to the log
+func (l *writeAheadLog) append(msg GossipMessage) error {
+	if err := writeRecord(l.file, msg); err != nil {
+		return err
+	}
+	l.records++
+	return nil
+}
+
+// needsCompaction reports whether most records belong to messages that are no longer stored
+func (l *writeAheadLog) needsCompaction(live int) bool {
+	return l.records >= compactMinRecords && l.records > 2*live
+}
+
+// compact replaces the log with one holding only messages. The new log is
+// written next to the old one and renamed over it, so a crash leaves either.
+func (l *writeAheadLog) compact(messages []GossipMessage) error {
+	tmppath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmppath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted log: %v", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, msg := range messages {
+		if err := writeRecord(writer, msg); err != nil {
+			tmp.Close()
+			os.Remove(tmppath)
+			return err
+		}
+	}
+	if err := errors.Join(writer.Flush(), tmp.Sync()); err != nil {
+		tmp.Close()
+		os.Remove(tmppath)
+		return fmt.Errorf("failed to write compacted log: %v", err)
+	}
+	if err := os.Rename(tmppath, l.path); err != nil {
+		tmp.Close()
+		os.Remove(tmppath)
+		return fmt.Errorf("failed to replace log: %v", err)
+	}
+
+	l.file.Close()
+	l.file = tmp
+	l.records = len(messages)
+	return nil
+}
+
+// close flushes the log to disk
+func (l *writeAheadLog) close() error {
+	return errors.Join(l.file.Sync(), l.file.Close())
+}
+
+// recover replays the log in dir into the node's state and keeps it open
+// for the messages delivered from now on
+func (gn *GossipNode) recover(dir string) error {
+	wal, messages, existed, err := openLog(dir)
+	if err != nil {
+		return err
+	}
+
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	gn.dataDir = dir
+	gn.wal = wal
+	gn.catchUp = existed
+
+	now := time.Now()
+	restored := 0
+	for _, msg := range messages {
+		if gn.expired(msg, now) || !gn.seen.add(msg.ID, msg.Timestamp) {
+			continue
+		}
+		gn.receivedMsgs = append(gn.receivedMsgs, msg)
+		gn.clock.Merge(msg.Clock)
+		restored++
+	}
+
+	if existed {
+		gn.Logger().WithFields(log.Fields{"Records": len(messages), "Restored": restored}).Info("Recovered message log")
+	}
+	return nil
+}
+
+// store keeps a delivered message for anti-entropy and appends it to the
+// log. Must be called with gn.mu held.
+func (gn *GossipNode) store(msg GossipMessage) {
+	gn.receivedMsgs = append(gn.receivedMsgs, msg)
+
+	if gn.wal == nil {
+		return
+	}
+	if err := gn.wal.append(msg); err != nil {
+		gn.Logger().WithField("MsgID", msg.ID).WithError(err).Warn("Failed to log message")
+	}
+}
+
+// compactLog rewrites the log once most of it is expired messages
+func (gn *GossipNode) compactLog() {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	if gn.wal == nil || !gn.wal.needsCompaction(len(gn.receivedMsgs)) {
+		return
+	}
+	records := gn.wal.records
+	if err := gn.wal.compact(gn.receivedMsgs); err != nil {
+		gn.Logger().WithError(err).Warn("Failed to compact message log")
+		return
+	}
+	gn.Logger().WithFields(log.Fields{"Records": records, "Kept": len(gn.receivedMsgs)}).Debug("Compacted message log")
+}
+
+// catchUpRound exchanges digests with a few peers after a restart, as soon
+// as the node has peers, and again until one of them answers
+func (gn *GossipNode) catchUpRound() {
+	gn.mu.Lock()
+	if !gn.catchUp || len(gn.peers) == 0 {
+		gn.mu.Unlock()
+		return
+	}
+	if gn.catchUpWait > 0 {
+		gn.catchUpWait--
+		gn.mu.Unlock()
+		return
+	}
+	gn.catchUpWait = catchUpRetryRounds
+	peers := append([]network.Address(nil), gn.peers...)
+	pages := gn.digestPages()
+	gn.mu.Unlock()
+
+	// the answer to the last page ends the catch-up
+	pages[len(pages)-1].CatchUp = true
+
+	mathrand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > catchUpPeers {
+		peers = peers[:catchUpPeers]
+	}
+
+	gn.Logger().WithField("Peers", len(peers)).Info("Catching up after restart")
+	for _, peer := range peers {
+		for _, page := range pages {
+			gn.sendDigest(peer, page)
+		}
+	}
+}
+
+// caughtUp ends the catch-up once a peer answered the digest
+func (gn *GossipNode) caughtUp(from network.Address) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	if gn.catchUp {
+		gn.catchUp = false
+		gn.Logger().WithField("Peer", from.String()).Info("Caught up after restart")
+	}
+}
+
+// closeLog flushes the log to disk when the node shuts down
+func (gn *GossipNode) closeLog() {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	if gn.wal == nil {
+		return
+	}
+	if err := gn.wal.close(); err != nil {
+		gn.Logger().WithError(err).Warn("Failed to close message log")
+	}
+	gn.wal = nil
+}
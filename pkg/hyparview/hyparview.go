@@ -0,0 +1,587 @@
+package hyparview
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerSet is anything that keeps a list of peers, such as gossip.GossipNode
+type PeerSet interface {
+	AddPeer(addr network.Address)
+	RemovePeer(addr network.Address)
+}
+
+// Sender sends messages to other nodes, such as node.Node or a
+// gossip.GossipNode that batches them with its rumors
+type Sender interface {
+	Send(to network.Address, msgType string, data []byte) error
+}
+
+// Config contains the HyParView protocol parameters
+type Config struct {
+	ActiveSize      int           // peers used for dissemination, about log(n)+1
+	PassiveSize     int           // backup peers used to repair the active view
+	ActiveWalk      int           // length of the random walk of a forward-join (ARWL)
+	PassiveWalk     int           // walk step at which the joining node enters the passive view (PRWL)
+	ShuffleActive   int           // active peers included in a shuffle sample
+	ShufflePassive  int           // passive peers included in a shuffle sample
+	ShuffleInterval time.Duration // how often the passive view is shuffled and the active view checked
+	ProbeTimeout    time.Duration // how long an active peer or neighbor request may take to answer
+}
+
+// DefaultConfig returns parameters suitable for clusters of up to a few hundred nodes
+func DefaultConfig() Config {
+	return Config{
+		ActiveSize:      5,
+		PassiveSize:     30,
+		ActiveWalk:      6,
+		PassiveWalk:     3,
+		ShuffleActive:   3,
+		ShufflePassive:  4,
+		ShuffleInterval: time.Second,
+		ProbeTimeout:    300 * time.Millisecond,
+	}
+}
+
+// message is the body of every HyParView message
+type message struct {
+	Node     network.Address   `json:"node"`               // joining node or shuffle origin
+	TTL      int               `json:"ttl,omitempty"`      // remaining random walk steps
+	Priority bool              `json:"priority,omitempty"` // neighbor request that must not be refused
+	Accepted bool              `json:"accepted,omitempty"` // answer to a neighbor request
+	Nodes    []network.Address `json:"nodes,omitempty"`    // shuffle sample
+}
+
+// Overlay maintains a HyParView partial view of the cluster: a small
+// symmetric active view used for dissemination and a larger passive view
+// that is shuffled continuously and used to replace failed active peers
+type Overlay struct {
+	node   *node.Node
+	sender Sender
+	config Config
+
+	mu          sync.Mutex
+	active      []network.Address
+	passive     []network.Address
+	lastShuffle []network.Address             // sample sent with our last shuffle
+	pending     map[network.Address]chan bool // outstanding neighbor requests
+	peers       []PeerSet
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates an overlay on top of n. Call Join and Start to take part.
+func New(n *node.Node, config Config) *Overlay {
+	o := &Overlay{
+		node:    n,
+		sender:  n,
+		config:  config,
+		active:  make([]network.Address, 0, config.ActiveSize),
+		passive: make([]network.Address, 0, config.PassiveSize),
+		pending: make(map[network.Address]chan bool),
+		stop:    make(chan struct{}),
+	}
+
+	n.Handle("hpv-join", o.handleJoin)
+	n.Handle("hpv-forward-join", o.handleForwardJoin)
+	n.Handle("hpv-neighbor", o.handleNeighbor)
+	n.Handle("hpv-neighbor-reply", o.handleNeighborReply)
+	n.Handle("hpv-disconnect", o.handleDisconnect)
+	n.Handle("hpv-shuffle", o.handleShuffle)
+	n.Handle("hpv-shuffle-reply", o.handleShuffleReply)
+
+	return o
+}
+
+// SetSender sends the overlay's messages through s instead of the node,
+// for example a gossip node that batches them. It must be called before
+// Join and Start.
+func (o *Overlay) SetSender(s Sender) {
+	o.sender = s
+}
+
+// Bind keeps peers equal to the active view. PeerSet methods are called
+// with the overlay locked and must not call back into it.
+func (o *Overlay) Bind(peers PeerSet) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, addr := range o.active {
+		peers.AddPeer(addr)
+	}
+	o.peers = append(o.peers, peers)
+}
+
+// Join enters the overlay through contact, which can be any member
+func (o *Overlay) Join(contact network.Address) error {
+	if contact == o.node.Address() {
+		return nil // the first node has nobody to join
+	}
+
+	if err := o.send(contact, "hpv-join", message{Node: o.node.Address()}); err != nil {
+		return fmt.Errorf("failed to join through %s: %w", contact.String(), err)
+	}
+
+	o.mu.Lock()
+	evicted := o.addActive(contact)
+	o.mu.Unlock()
+	o.disconnect(evicted)
+
+	return nil
+}
+
+// Start shuffles and repairs the views until Stop is called
+func (o *Overlay) Start() {
+	go func() {
+		ticker := time.NewTicker(o.config.ShuffleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+				o.checkActive()
+				o.repair()
+				o.shuffle()
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic maintenance. The node keeps answering requests until it is closed.
+func (o *Overlay) Stop() {
+	o.stopOnce.Do(func() { close(o.stop) })
+}
+
+// Active returns a copy of the active view
+func (o *Overlay) Active() []network.Address {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]network.Address(nil), o.active...)
+}
+
+// Passive returns a copy of the passive view
+func (o *Overlay) Passive() []network.Address {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]network.Address(nil), o.passive...)
+}
+
+// PeerFailed removes a peer that another failure detector, such as SWIM,
+// declared dead. The active view is refilled in the next round.
+func (o *Overlay) PeerFailed(addr network.Address) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.removeActive(addr)
+	o.passive = remove(o.passive, addr)
+}
+
+func (o *Overlay) handleJoin(msg network.Message) error {
+	body, err := o.decode(msg)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	evicted := o.addActive(body.Node)
+	others := without(o.active, body.Node)
+	o.mu.Unlock()
+	o.disconnect(evicted)
+
+	// let the new node random-walk into the views of the rest of the overlay
+	for _, peer := range others {
+		o.send(peer, "hpv-forward-join", message{Node: body.Node, TTL: o.config.ActiveWalk})
+	}
+	return nil
+}
+
+func (o *Overlay) handleForwardJoin(msg network.Message) error {
+	body, err := o.decode(msg)
+	if err != nil {
+		return err
+	}
+	if body.Node == o.node.Address() {
+		return nil
+	}
+
+	o.mu.Lock()
+	if body.TTL <= 0 || len(o.active) <= 1 {
+		o.mu.Unlock()
+		o.connect(body.Node)
+		return nil
+	}
+
+	if body.TTL == o.config.PassiveWalk {
+		o.addPassive(body.Node)
+	}
+	next, ok := randomOf(without(without(o.active, msg.From), body.Node))
+	o.mu.Unlock()
+
+	if !ok {
+		o.connect(body.Node)
+		return nil
+	}
+	o.send(next, "hpv-forward-join", message{Node: body.Node, TTL: body.TTL - 1})
+	return nil
+}
+
+func (o *Overlay) handleNeighbor(msg network.Message) error {
+	body, err := o.decode(msg)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	accepted := body.Priority || len(o.active) < o.config.ActiveSize || contains(o.active, msg.From)
+	var evicted network.Address
+	if accepted {
+		evicted = o.addActive(msg.From)
+	}
+	o.mu.Unlock()
+	o.disconnect(evicted)
+
+	o.send(msg.From, "hpv-neighbor-reply", message{Node: o.node.Address(), Accepted: accepted})
+	return nil
+}
+
+func (o *Overlay) handleNeighborReply(msg network.Message) error {
+	body, err := o.decode(msg)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	reply, exists := o.pending[msg.From]
+	o.mu.Unlock()
+
+	if exists {
+		select {
+		case reply <- body.Accepted:
+		default:
+		}
+	}
+	return nil
+}
+
+func (o *Overlay) handleDisconnect(msg network.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.removeActive(msg.From) {
+		o.addPassive(msg.From)
+	}
+	return nil
+}
+
+func (o *Overlay) handleShuffle(msg network.Message) error {
+	body, err := o.decode(msg)
+	if err != nil {
+		return err
+	}
+	if body.Node == o.node.Address() {
+		return nil
+	}
+
+	o.mu.Lock()
+	body.TTL--
+	if body.TTL > 0 && len(o.active) > 1 {
+		next, ok := randomOf(without(without(o.active, msg.From), body.Node))
+		if ok {
+			o.mu.Unlock()
+			o.send(next, "hpv-shuffle", body)
+			return nil
+		}
+	}
+
+	// the walk ends here, answer with a sample of our passive view
+	reply := sample(o.passive, len(body.Nodes))
+	o.integrate(body.Nodes, reply)
+	o.mu.Unlock()
+
+	o.send(body.Node, "hpv-shuffle-reply", message{Node: o.node.Address(), Nodes: reply})
+	return nil
+}
+
+func (o *Overlay) handleShuffleReply(msg network.Message) error {
+	body, err := o.decode(msg)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.integrate(body.Nodes, o.lastShuffle)
+	return nil
+}
+
+// shuffle sends a sample of both views on a random walk, so passive views
+// keep changing and stay a random sample of the cluster
+func (o *Overlay) shuffle() {
+	o.mu.Lock()
+	target, ok := randomOf(o.active)
+	if !ok {
+		o.mu.Unlock()
+		return
+	}
+
+	nodes := []network.Address{o.node.Address()}
+	nodes = append(nodes, sample(without(o.active, target), o.config.ShuffleActive)...)
+	nodes = append(nodes, sample(o.passive, o.config.ShufflePassive)...)
+	o.lastShuffle = nodes
+	o.mu.Unlock()
+
+	o.send(target, "hpv-shuffle", message{Node: o.node.Address(), TTL: o.config.ActiveWalk, Nodes: nodes})
+}
+
+// checkActive pings every active peer and drops the ones that do not answer
+func (o *Overlay) checkActive() {
+	var wg sync.WaitGroup
+	for _, peer := range o.Active() {
+		wg.Add(1)
+		go func(addr network.Address) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), o.config.ProbeTimeout)
+			defer cancel()
+			if _, err := o.node.Ping(ctx, addr); err != nil {
+				o.node.Logger().WithField("Peer", addr.String()).WithError(err).Debug("Active peer failed")
+				o.PeerFailed(addr)
+				// if the peer was only slow, make it drop us as well so the
+				// views stay symmetric and it repairs its own view
+				o.disconnect(addr)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// repair promotes passive peers until the active view is full again. A
+// node without any active peer asks with high priority, so it cannot be
+// refused and left isolated.
+func (o *Overlay) repair() {
+	o.mu.Lock()
+	candidates := sample(o.passive, len(o.passive))
+	o.mu.Unlock()
+
+	for _, candidate := range candidates {
+		o.mu.Lock()
+		missing := o.config.ActiveSize - len(o.active)
+		priority := len(o.active) == 0
+		o.mu.Unlock()
+		if missing <= 0 {
+			return
+		}
+
+		accepted, err := o.requestNeighbor(candidate, priority)
+		if err != nil {
+			// the candidate is gone as well
+			o.mu.Lock()
+			o.passive = remove(o.passive, candidate)
+			o.mu.Unlock()
+			continue
+		}
+		if accepted {
+			o.mu.Lock()
+			evicted := o.addActive(candidate)
+			o.mu.Unlock()
+			o.disconnect(evicted)
+		}
+	}
+}
+
+// requestNeighbor asks addr to add us to its active view and waits for the answer
+func (o *Overlay) requestNeighbor(addr network.Address, priority bool) (bool, error) {
+	reply := make(chan bool, 1)
+	o.mu.Lock()
+	o.pending[addr] = reply
+	o.mu.Unlock()
+
+	defer func() {
+		o.mu.Lock()
+		delete(o.pending, addr)
+		o.mu.Unlock()
+	}()
+
+	if err := o.send(addr, "hpv-neighbor", message{Node: o.node.Address(), Priority: priority}); err != nil {
+		return false, err
+	}
+
+	select {
+	case accepted := <-reply:
+		return accepted, nil
+	case <-time.After(o.config.ProbeTimeout):
+		return false, fmt.Errorf("neighbor request to %s timed out", addr.String())
+	case <-o.stop:
+		return false, fmt.Errorf("overlay stopped")
+	}
+}
+
+// connect adds addr to the active view and tells it to do the same
+func (o *Overlay) connect(addr network.Address) {
+	o.mu.Lock()
+	evicted := o.addActive(addr)
+	o.mu.Unlock()
+	o.disconnect(evicted)
+
+	o.send(addr, "hpv-neighbor", message{Node: o.node.Address(), Priority: true})
+}
+
+// disconnect tells a peer evicted from the active view to drop us as well
+func (o *Overlay) disconnect(addr network.Address) {
+	if addr == (network.Address{}) {
+		return
+	}
+	o.send(addr, "hpv-disconnect", message{Node: o.node.Address()})
+}
+
+// addActive adds addr to the active view, evicting a random peer to the
+// passive view if it is full. It returns the evicted peer, which must be
+// sent a disconnect. Must be called with o.mu held.
+func (o *Overlay) addActive(addr network.Address) network.Address {
+	if addr == o.node.Address() || contains(o.active, addr) {
+		return network.Address{}
+	}
+
+	var evicted network.Address
+	if len(o.active) >= o.config.ActiveSize {
+		evicted = o.active[mathrand.Intn(len(o.active))]
+		o.removeActive(evicted)
+		o.addPassive(evicted)
+	}
+
+	o.passive = remove(o.passive, addr)
+	o.active = append(o.active, addr)
+	for _, peers := range o.peers {
+		peers.AddPeer(addr)
+	}
+	return evicted
+}
+
+// removeActive drops addr from the active view and reports whether it was
+// there. Must be called with o.mu held.
+func (o *Overlay) removeActive(addr network.Address) bool {
+	if !contains(o.active, addr) {
+		return false
+	}
+
+	o.active = remove(o.active, addr)
+	for _, peers := range o.peers {
+		peers.RemovePeer(addr)
+	}
+	return true
+}
+
+// addPassive adds addr to the passive view, evicting a random entry if it
+// is full. Must be called with o.mu held.
+func (o *Overlay) addPassive(addr network.Address) {
+	if addr == o.node.Address() || contains(o.active, addr) || contains(o.passive, addr) {
+		return
+	}
+	if len(o.passive) >= o.config.PassiveSize {
+		o.passive = remove(o.passive, o.passive[mathrand.Intn(len(o.passive))])
+	}
+	o.passive = append(o.passive, addr)
+}
+
+// integrate merges a shuffle sample into the passive view, preferring to
+// evict the entries we sent away. Must be called with o.mu held.
+func (o *Overlay) integrate(nodes, sent []network.Address) {
+	for _, addr := range nodes {
+		if addr == o.node.Address() || contains(o.active, addr) || contains(o.passive, addr) {
+			continue
+		}
+		if len(o.passive) >= o.config.PassiveSize {
+			victim := o.passive[mathrand.Intn(len(o.passive))]
+			for _, candidate := range sent {
+				if contains(o.passive, candidate) {
+					victim = candidate
+					break
+				}
+			}
+			o.passive = remove(o.passive, victim)
+		}
+		o.passive = append(o.passive, addr)
+	}
+}
+
+func (o *Overlay) send(to network.Address, msgType string, msg message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %v", msgType, err)
+	}
+	if err := o.sender.Send(to, msgType, data); err != nil {
+		o.node.Logger().WithFields(log.Fields{"MsgType": msgType, "Peer": to.String()}).WithError(err).Debug("HyParView send failed")
+		return err
+	}
+	return nil
+}
+
+func (o *Overlay) decode(msg network.Message) (message, error) {
+	_, data := node.ParsePayload(msg.Payload)
+
+	var body message
+	if err := json.Unmarshal(data, &body); err != nil {
+		return message{}, fmt.Errorf("failed to unmarshal HyParView message: %v", err)
+	}
+	return body, nil
+}
+
+func contains(addrs []network.Address, addr network.Address) bool {
+	for _, existing := range addrs {
+		if existing == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// remove returns addrs without addr, reusing its backing array
+func remove(addrs []network.Address, addr network.Address) []network.Address {
+	for i, existing := range addrs {
+		if existing == addr {
+			return append(addrs[:i], addrs[i+1:]...)
+		}
+	}
+	return addrs
+}
+
+// without returns a copy of addrs without addr
+func without(addrs []network.Address, addr network.Address) []network.Address {
+	result := make([]network.Address, 0, len(addrs))
+	for _, existing := range addrs {
+		if existing != addr {
+			result = append(result, existing)
+		}
+	}
+	return result
+}
+
+// sample returns up to k random entries of addrs
+func sample(addrs []network.Address, k int) []network.Address {
+	result := append([]network.Address(nil), addrs...)
+	mathrand.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+func randomOf(addrs []network.Address) (network.Address, bool) {
+	if len(addrs) == 0 {
+		return network.Address{}, false
+	}
+	return addrs[mathrand.Intn(len(addrs))], true
+}
@@ -0,0 +1,158 @@
+package hyparview
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+func testConfig() Config {
+	return Config{
+		ActiveSize:      4,
+		PassiveSize:     12,
+		ActiveWalk:      4,
+		PassiveWalk:     2,
+		ShuffleActive:   2,
+		ShufflePassive:  3,
+		ShuffleInterval: 30 * time.Millisecond,
+		ProbeTimeout:    20 * time.Millisecond,
+	}
+}
+
+func startOverlay(t *testing.T, net network.Network, size int) ([]*node.Node, []*Overlay) {
+	nodes := make([]*node.Node, size)
+	overlays := make([]*Overlay, size)
+	for i := 0; i < size; i++ {
+		n, err := node.NewNode(net, network.Address{IP: "127.0.0.1", Port: 9000 + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		overlays[i] = New(n, testConfig())
+		n.Start()
+
+		if i > 0 {
+			if err := overlays[i].Join(nodes[i-1].Address()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		overlays[i].Start()
+	}
+	return nodes, overlays
+}
+
+// connected reports whether the active views of the live nodes form a
+// connected graph
+func connected(nodes []*node.Node, overlays []*Overlay, live map[int]bool) bool {
+	index := make(map[network.Address]int)
+	for i, n := range nodes {
+		index[n.Address()] = i
+	}
+
+	start := -1
+	for i := range live {
+		start = i
+		break
+	}
+	visited := map[int]bool{start: true}
+	queue := []int{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, addr := range overlays[current].Active() {
+			next := index[addr]
+			if live[next] && !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return len(visited) == len(live)
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOverlayFormsBoundedViews(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, overlays := startOverlay(t, net, 20)
+
+	live := make(map[int]bool)
+	for i := range nodes {
+		live[i] = true
+	}
+	waitFor(t, 5*time.Second, "a connected overlay", func() bool {
+		return connected(nodes, overlays, live)
+	})
+
+	// let a few shuffles fill the passive views
+	time.Sleep(300 * time.Millisecond)
+
+	for i, overlay := range overlays {
+		active, passive := overlay.Active(), overlay.Passive()
+		if len(active) > testConfig().ActiveSize {
+			t.Errorf("node %d has %d active peers, more than the configured %d", i, len(active), testConfig().ActiveSize)
+		}
+		if len(passive) == 0 {
+			t.Errorf("node %d has an empty passive view", i)
+		}
+		for _, addr := range active {
+			if addr == nodes[i].Address() {
+				t.Errorf("node %d has itself in its active view", i)
+			}
+		}
+	}
+
+	for i := range overlays {
+		overlays[i].Stop()
+		nodes[i].Close()
+	}
+}
+
+func TestOverlayRepairsAfterFailures(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, overlays := startOverlay(t, net, 20)
+
+	live := make(map[int]bool)
+	for i := range nodes {
+		live[i] = true
+	}
+	waitFor(t, 5*time.Second, "a connected overlay", func() bool {
+		return connected(nodes, overlays, live)
+	})
+	time.Sleep(300 * time.Millisecond)
+
+	// crash a quarter of the cluster
+	for i := 0; i < len(nodes); i += 4 {
+		overlays[i].Stop()
+		nodes[i].Close()
+		delete(live, i)
+	}
+
+	waitFor(t, 5*time.Second, "the failed peers to be replaced", func() bool {
+		for i := range live {
+			for _, addr := range overlays[i].Active() {
+				for j := range nodes {
+					if !live[j] && nodes[j].Address() == addr {
+						return false
+					}
+				}
+			}
+		}
+		return connected(nodes, overlays, live)
+	})
+
+	for i := range live {
+		overlays[i].Stop()
+		nodes[i].Close()
+	}
+}
@@ -1,7 +1,22 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+)
+
+// Errors returned by Network and Connection implementations
+var (
+	ErrAddressInUse        = errors.New("address already in use")
+	ErrAddressNotFound     = errors.New("address not found")
+	ErrDestinationNotFound = errors.New("destination address not found")
+	ErrPartitioned         = errors.New("network partitioned")
+	ErrQueueFull           = errors.New("message queue full") // transient, the receiver is busy
+	ErrNotListening        = errors.New("connection not listening")
+	ErrConnectionClosed    = errors.New("connection closed")
+	ErrMessageTooLarge     = errors.New("message too large for the transport")
 )
 
 type Address struct {
@@ -13,6 +28,19 @@ func (a Address) String() string {
 	return fmt.Sprintf("%s:%d", a.IP, a.Port)
 }
 
+// ParseAddress parses a "host:port" string as produced by Address.String
+func ParseAddress(s string) (Address, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Address{}, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+	iport, err := strconv.Atoi(port)
+	if err != nil || iport < 1 || iport > 65535 {
+		return Address{}, fmt.Errorf("invalid port in address %q", s)
+	}
+	return Address{IP: host, Port: iport}, nil
+}
+
 type Network interface {
 	Listen(addr Address) (Connection, error)
 	Dial(addr Address) (Connection, error)
@@ -31,6 +59,8 @@ type Connection interface {
 type Message struct {
 	From    Address
 	To      Address
+	FromID  string // sender's node id (hex), empty if the sender did not set one
+	ToID    string // receiver's node id (hex), set by the receiving node so replies carry it
 	Payload []byte
 	network Network // Reference to network for replies
 }
@@ -41,6 +71,7 @@ func (m Message) ReplyString(prefix string, message string) error {
 	reply := Message{
 		From:    m.To,
 		To:      m.From,
+		FromID:  m.ToID,
 		Payload: payload,
 		network: m.network,
 	}
@@ -48,6 +79,7 @@ func (m Message) ReplyString(prefix string, message string) error {
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 	return conn.Send(reply)
 
 }
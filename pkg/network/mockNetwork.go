@@ -1,7 +1,6 @@
 package network
 
 import (
-	"errors"
 	"sync"
 )
 
@@ -22,7 +21,7 @@ func (n *mockNetwork) Listen(addr Address) (Connection, error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	if _, exists := n.listeners[addr]; exists {
-		return nil, errors.New("address already in use")
+		return nil, ErrAddressInUse
 	}
 	ch := make(chan Message, 100) // buffered channel
 	n.listeners[addr] = ch
@@ -33,7 +32,7 @@ func (n *mockNetwork) Dial(addr Address) (Connection, error) {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 	if _, exists := n.listeners[addr]; !exists {
-		return nil, errors.New("address not found")
+		return nil, ErrAddressNotFound
 	}
 	return &mockConnection{addr: addr, network: n}, nil
 }
@@ -68,13 +67,13 @@ func (c *mockConnection) Send(msg Message) error {
 
 	if c.network.partitions[c.addr] || c.network.partitions[msg.To] {
 		c.network.mu.RUnlock()
-		return errors.New("network partitioned")
+		return ErrPartitioned
 	}
 
 	ch, exists := c.network.listeners[msg.To]
 	if !exists {
 		c.network.mu.RUnlock()
-		return errors.New("destination address not found")
+		return ErrDestinationNotFound
 	}
 
 	// Add network reference to message for replies
@@ -87,7 +86,7 @@ func (c *mockConnection) Send(msg Message) error {
 		return nil
 	default:
 		c.network.mu.RUnlock()
-		return errors.New("message queue full")
+		return ErrQueueFull
 	}
 }
 
@@ -95,14 +94,14 @@ func (c *mockConnection) Recv() (Message, error) {
 	c.mu.RLock()
 	if c.closed || c.recvCh == nil {
 		c.mu.RUnlock()
-		return Message{}, errors.New("connection not listening")
+		return Message{}, ErrNotListening
 	}
 	ch := c.recvCh
 	c.mu.RUnlock()
 
 	msg, ok := <-ch
 	if !ok {
-		return Message{}, errors.New("connection closed")
+		return Message{}, ErrConnectionClosed
 	}
 	return msg, nil
 }
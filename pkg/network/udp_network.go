@@ -1,40 +1,61 @@
 package network
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"sync"
 )
 
+// maxDatagramSize is the largest UDP payload over IPv4
+const maxDatagramSize = 65507
+
+// udpPacket is how a Message travels in a datagram. The addresses are the
+// ones the nodes were started with, such as container host names, so that
+// replies go to the address the sender listens on.
+type udpPacket struct {
+	From    Address `json:"from"`
+	To      Address `json:"to"`
+	FromID  string  `json:"from_id,omitempty"`
+	Payload []byte  `json:"payload"`
+}
+
+// udpNetwork sends every message as one UDP datagram. Host names are
+// resolved on every send, so nodes that restart with a new IP are found
+// again. Partition only drops messages of the nodes in this process.
 type udpNetwork struct {
 	mu         sync.RWMutex
-	listeners  map[Address]chan Message
+	listeners  map[Address]*net.UDPConn
 	partitions map[Address]bool // true if the address is partitioned
 }
 
 func NewUDPNetwork() Network {
-	return &mockNetwork{
-		listeners:  make(map[Address]chan Message),
+	return &udpNetwork{
+		listeners:  make(map[Address]*net.UDPConn),
 		partitions: make(map[Address]bool),
 	}
 }
 
+// Listen binds the port of addr on all interfaces, since addr.IP is often a
+// host name that other nodes resolve to one of them
 func (n *udpNetwork) Listen(addr Address) (Connection, error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	if _, exists := n.listeners[addr]; exists {
-		return nil, errors.New("address already in use")
+		return nil, ErrAddressInUse
+	}
+
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{Port: addr.Port})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on port %d: %w", addr.Port, err)
 	}
-	ch := make(chan Message, 100) // buffered channel
-	n.listeners[addr] = ch
-	return &udpConnection{addr: addr, network: n, recvCh: ch}, nil
+	n.listeners[addr] = socket
+	return &udpConnection{addr: addr, network: n, socket: socket, listening: true}, nil
 }
 
+// Dial does not contact addr, a datagram is only sent by Send
 func (n *udpNetwork) Dial(addr Address) (Connection, error) {
-	n.mu.RLock()
-	defer n.mu.RUnlock()
-	if _, exists := n.listeners[addr]; !exists {
-		return nil, errors.New("address not found")
-	}
 	return &udpConnection{addr: addr, network: n}, nil
 }
 
@@ -55,56 +76,107 @@ func (n *udpNetwork) Heal() {
 	n.partitions = make(map[Address]bool)
 }
 
+func (n *udpNetwork) resolve(addr Address) (*net.UDPAddr, error) {
+	udpaddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAddressNotFound, err)
+	}
+	return udpaddr, nil
+}
+
 type udpConnection struct {
-	addr    Address
-	network *udpNetwork
-	recvCh  chan Message
-	mu      sync.RWMutex
-	closed  bool
+	addr      Address
+	network   *udpNetwork
+	socket    *net.UDPConn // the listening socket, or the one Send opened for a dialed connection
+	listening bool
+	mu        sync.Mutex
+	closed    bool
 }
 
+// Send writes msg from the socket that listens on msg.From, so that the
+// datagram comes from the port the sender is known by
 func (c *udpConnection) Send(msg Message) error {
 	c.network.mu.RLock()
+	partitioned := c.network.partitions[msg.From] || c.network.partitions[msg.To]
+	socket := c.network.listeners[msg.From]
+	c.network.mu.RUnlock()
+	if partitioned {
+		return ErrPartitioned
+	}
 
-	if c.network.partitions[c.addr] || c.network.partitions[msg.To] {
-		c.network.mu.RUnlock()
-		return errors.New("network partitioned")
+	data, err := json.Marshal(udpPacket{From: msg.From, To: msg.To, FromID: msg.FromID, Payload: msg.Payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal packet: %v", err)
+	}
+	if len(data) > maxDatagramSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
 	}
 
-	ch, exists := c.network.listeners[msg.To]
-	if !exists {
-		c.network.mu.RUnlock()
-		return errors.New("destination address not found")
+	to, err := c.network.resolve(msg.To)
+	if err != nil {
+		return err
 	}
 
-	// Add network reference to message for replies
-	msg.network = c.network
+	if socket == nil {
+		if socket, err = c.ephemeralSocket(); err != nil {
+			return err
+		}
+	}
+	if _, err := socket.WriteToUDP(data, to); err != nil {
+		return fmt.Errorf("%w: %v", ErrDestinationNotFound, err)
+	}
+	return nil
+}
 
-	// Keep the lock while sending to prevent the channel from being closed
-	select {
-	case ch <- msg:
-		c.network.mu.RUnlock()
-		return nil
-	default:
-		c.network.mu.RUnlock()
-		return errors.New("message queue full")
+// ephemeralSocket opens a socket for a sender that does not listen in this process
+func (c *udpConnection) ephemeralSocket() (*net.UDPConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrConnectionClosed
+	}
+	if c.socket == nil {
+		socket, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open socket: %w", err)
+		}
+		c.socket = socket
 	}
+	return c.socket, nil
 }
 
+// Recv returns the next message. Datagrams that are not packets are skipped.
 func (c *udpConnection) Recv() (Message, error) {
-	c.mu.RLock()
-	if c.closed || c.recvCh == nil {
-		c.mu.RUnlock()
-		return Message{}, errors.New("connection not listening")
+	c.mu.Lock()
+	if c.closed || !c.listening {
+		c.mu.Unlock()
+		return Message{}, ErrNotListening
 	}
-	ch := c.recvCh
-	c.mu.RUnlock()
+	socket := c.socket
+	c.mu.Unlock()
 
-	msg, ok := <-ch
-	if !ok {
-		return Message{}, errors.New("connection closed")
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		length, _, err := socket.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return Message{}, ErrConnectionClosed
+			}
+			return Message{}, err
+		}
+
+		var packet udpPacket
+		if err := json.Unmarshal(buffer[:length], &packet); err != nil {
+			continue
+		}
+		return Message{
+			From:    packet.From,
+			To:      packet.To,
+			FromID:  packet.FromID,
+			Payload: packet.Payload,
+			network: c.network,
+		}, nil
 	}
-	return msg, nil
 }
 
 func (c *udpConnection) Close() error {
@@ -114,15 +186,16 @@ func (c *udpConnection) Close() error {
 		return nil // Already closed
 	}
 	c.closed = true
+	socket := c.socket
 	c.mu.Unlock()
 
-	c.network.mu.Lock()
-	defer c.network.mu.Unlock()
-
-	if c.recvCh != nil {
-		close(c.recvCh)
+	if socket == nil {
+		return nil
+	}
+	if c.listening {
+		c.network.mu.Lock()
 		delete(c.network.listeners, c.addr)
-		c.recvCh = nil
+		c.network.mu.Unlock()
 	}
-	return nil
+	return socket.Close()
 }
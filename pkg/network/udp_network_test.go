@@ -0,0 +1,75 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// recvTimeout receives from conn or fails the test after a second
+func recvTimeout(t *testing.T, conn Connection) Message {
+	t.Helper()
+	received := make(chan Message, 1)
+	go func() {
+		if msg, err := conn.Recv(); err == nil {
+			received <- msg
+		}
+	}()
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no datagram received")
+		return Message{}
+	}
+}
+
+func TestUDPNetworkRoundTrip(t *testing.T) {
+	net := NewUDPNetwork()
+	alice := Address{IP: "localhost", Port: 47811}
+	bob := Address{IP: "127.0.0.1", Port: 47812}
+
+	aliceConn, err := net.Listen(alice)
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	defer aliceConn.Close()
+	bobConn, err := net.Listen(bob)
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	defer bobConn.Close()
+
+	conn, err := net.Dial(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send(Message{From: alice, To: bob, FromID: "a1", Payload: []byte("ping:hello")}); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	msg := recvTimeout(t, bobConn)
+	if msg.From != alice || msg.FromID != "a1" || string(msg.Payload) != "ping:hello" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+
+	// the reply goes to the address alice is known by
+	if err := msg.ReplyString("pong", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	reply := recvTimeout(t, aliceConn)
+	if reply.From != bob || string(reply.Payload) != "pong:hi" {
+		t.Errorf("unexpected reply %+v", reply)
+	}
+
+	// a closed listener frees its address
+	bobConn.Close()
+	if _, err := bobConn.Recv(); err == nil {
+		t.Errorf("expected Recv to fail after Close")
+	}
+	again, err := net.Listen(bob)
+	if err != nil {
+		t.Fatalf("expected to listen again after Close: %v", err)
+	}
+	again.Close()
+}
@@ -0,0 +1,147 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// DefaultDeadLetterCapacity is the number of dead letters a node keeps by default
+const DefaultDeadLetterCapacity = 100
+
+// DeadLetterReason describes why a message could not be processed
+type DeadLetterReason string
+
+const (
+	// ReasonNoHandler is used when neither a matching nor a "default" handler exists
+	ReasonNoHandler DeadLetterReason = "no handler"
+	// ReasonHandlerError is used when the handler returned an error
+	ReasonHandlerError DeadLetterReason = "handler error"
+	// ReasonRateLimited is used when the sender or message type exceeded its rate limit
+	ReasonRateLimited DeadLetterReason = "rate limited"
+	// ReasonSendFailed is used for outgoing messages that failed on every retry
+	ReasonSendFailed DeadLetterReason = "send failed"
+)
+
+// DeadLetter is a message that a node received but could not process, or
+// failed to send
+type DeadLetter struct {
+	Message   network.Message
+	MsgType   string
+	Reason    DeadLetterReason
+	Err       error // handler or send error, nil for unroutable messages
+	Timestamp time.Time
+}
+
+// DeadLetterStats contains counters for everything that was dead-lettered,
+// including entries that have since been evicted from the buffer
+type DeadLetterStats struct {
+	Total    int
+	Evicted  int
+	ByReason map[DeadLetterReason]int
+	ByType   map[string]int
+}
+
+// DeadLetterQueue is a bounded buffer of dead letters. When full, the oldest
+// entry is evicted to make room for the newest one.
+type DeadLetterQueue struct {
+	mu       sync.Mutex
+	entries  []DeadLetter
+	capacity int
+	total    int
+	evicted  int
+	byReason map[DeadLetterReason]int
+	byType   map[string]int
+}
+
+// NewDeadLetterQueue creates a dead-letter queue holding at most capacity entries
+func NewDeadLetterQueue(capacity int) *DeadLetterQueue {
+	if capacity <= 0 {
+		capacity = DefaultDeadLetterCapacity
+	}
+
+	return &DeadLetterQueue{
+		entries:  make([]DeadLetter, 0, capacity),
+		capacity: capacity,
+		byReason: make(map[DeadLetterReason]int),
+		byType:   make(map[string]int),
+	}
+}
+
+// Add records a dead letter, evicting the oldest entry if the buffer is full
+func (q *DeadLetterQueue) Add(dl DeadLetter) {
+	if dl.Timestamp.IsZero() {
+		dl.Timestamp = time.Now()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == q.capacity {
+		copy(q.entries, q.entries[1:])
+		q.entries = q.entries[:len(q.entries)-1]
+		q.evicted++
+	}
+	q.entries = append(q.entries, dl)
+
+	q.total++
+	q.byReason[dl.Reason]++
+	q.byType[dl.MsgType]++
+}
+
+// Entries returns the buffered dead letters, oldest first
+func (q *DeadLetterQueue) Entries() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]DeadLetter, len(q.entries))
+	copy(entries, q.entries)
+	return entries
+}
+
+// Drain returns the buffered dead letters and empties the buffer. Counters are kept.
+func (q *DeadLetterQueue) Drain() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := q.entries
+	q.entries = make([]DeadLetter, 0, q.capacity)
+	return entries
+}
+
+// Len returns the number of buffered dead letters
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// Count returns how many messages were dead-lettered for the given reason
+func (q *DeadLetterQueue) Count(reason DeadLetterReason) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.byReason[reason]
+}
+
+// Stats returns a snapshot of the dead-letter counters
+func (q *DeadLetterQueue) Stats() DeadLetterStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := DeadLetterStats{
+		Total:    q.total,
+		Evicted:  q.evicted,
+		ByReason: make(map[DeadLetterReason]int, len(q.byReason)),
+		ByType:   make(map[string]int, len(q.byType)),
+	}
+	for reason, count := range q.byReason {
+		stats.ByReason[reason] = count
+	}
+	for msgType, count := range q.byType {
+		stats.ByType[msgType] = count
+	}
+	return stats
+}
@@ -0,0 +1,62 @@
+package node
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestDeadLetters(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+
+	alice.Handle("fail", func(msg network.Message) error {
+		return errors.New("boom")
+	})
+
+	alice.Start()
+	bob.Start()
+	bob.SendString(alice.Address(), "unknown", "nobody handles this")
+	bob.SendString(alice.Address(), "fail", "handler fails")
+
+	// Wait until both messages have been dead-lettered
+	deadline := time.Now().Add(time.Second)
+	for alice.DeadLetters().Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := alice.DeadLetters().Stats()
+	if stats.ByReason[ReasonNoHandler] != 1 {
+		t.Errorf("expected 1 unroutable message, got %d", stats.ByReason[ReasonNoHandler])
+	}
+	if stats.ByReason[ReasonHandlerError] != 1 {
+		t.Errorf("expected 1 handler error, got %d", stats.ByReason[ReasonHandlerError])
+	}
+	if stats.ByType["unknown"] != 1 {
+		t.Errorf("expected 1 dead letter of type unknown, got %d", stats.ByType["unknown"])
+	}
+
+	alice.Close()
+	bob.Close()
+}
+
+func TestDeadLetterQueueEviction(t *testing.T) {
+	q := NewDeadLetterQueue(2)
+	for i := 0; i < 3; i++ {
+		q.Add(DeadLetter{MsgType: "test", Reason: ReasonNoHandler})
+	}
+
+	if q.Len() != 2 {
+		t.Errorf("expected 2 buffered entries, got %d", q.Len())
+	}
+	stats := q.Stats()
+	if stats.Total != 3 || stats.Evicted != 1 {
+		t.Errorf("expected total 3 and evicted 1, got %d and %d", stats.Total, stats.Evicted)
+	}
+	if len(q.Drain()) != 2 || q.Len() != 0 {
+		t.Errorf("expected drain to empty the buffer")
+	}
+}
@@ -0,0 +1,122 @@
+package node
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// NodeIDLength is the size of a node id in bytes (160 bits)
+const NodeIDLength = 20
+
+// NodeID is a stable identity for a node, independent of its address
+type NodeID [NodeIDLength]byte
+
+// NewRandomNodeID generates a random node id
+func NewRandomNodeID() (NodeID, error) {
+	var id NodeID
+	if _, err := rand.Read(id[:]); err != nil {
+		return NodeID{}, fmt.Errorf("failed to generate node id: %w", err)
+	}
+	return id, nil
+}
+
+// NodeIDFromKey derives a node id from a public key or any other stable secret
+func NodeIDFromKey(key []byte) NodeID {
+	return NodeID(sha1.Sum(key))
+}
+
+// ParseNodeID parses the hex representation produced by NodeID.String
+func ParseNodeID(s string) (NodeID, error) {
+	var id NodeID
+	bytes, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return NodeID{}, fmt.Errorf("invalid node id %q: %w", s, err)
+	}
+	if len(bytes) != NodeIDLength {
+		return NodeID{}, fmt.Errorf("invalid node id %q: expected %d bytes, got %d", s, NodeIDLength, len(bytes))
+	}
+	copy(id[:], bytes)
+	return id, nil
+}
+
+// LoadOrCreateNodeID reads the node id stored at path, or generates a new one
+// and stores it there if the file does not exist yet
+func LoadOrCreateNodeID(path string) (NodeID, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ParseNodeID(string(data))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return NodeID{}, fmt.Errorf("failed to read node id: %w", err)
+	}
+
+	id, err := NewRandomNodeID()
+	if err != nil {
+		return NodeID{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return NodeID{}, fmt.Errorf("failed to create node id directory: %w", err)
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated id behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(id.String()+"\n"), 0600); err != nil {
+		return NodeID{}, fmt.Errorf("failed to write node id: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return NodeID{}, fmt.Errorf("failed to write node id: %w", err)
+	}
+
+	return id, nil
+}
+
+// SenderID returns the node id carried in a message envelope, if any
+func SenderID(msg network.Message) (NodeID, bool) {
+	if msg.FromID == "" {
+		return NodeID{}, false
+	}
+	id, err := ParseNodeID(msg.FromID)
+	if err != nil {
+		return NodeID{}, false
+	}
+	return id, true
+}
+
+// String returns the id in hex
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Short returns an abbreviated id for log output
+func (id NodeID) Short() string {
+	return id.String()[:8]
+}
+
+// IsZero reports whether the id is unset
+func (id NodeID) IsZero() bool {
+	return id == NodeID{}
+}
+
+// MarshalText encodes the id as hex, so it shows up as a string in JSON
+func (id NodeID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes a hex encoded id
+func (id *NodeID) UnmarshalText(text []byte) error {
+	parsed, err := ParseNodeID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
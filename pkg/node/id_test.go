@@ -0,0 +1,57 @@
+package node
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestNodeIDPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "node.id")
+
+	first, err := LoadOrCreateNodeID(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadOrCreateNodeID(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || first.IsZero() {
+		t.Errorf("expected the same non-zero id after reload, got %s and %s", first, second)
+	}
+
+	// ids travel as hex strings in JSON
+	data, _ := json.Marshal(map[string]NodeID{"id": first})
+	var decoded map[string]NodeID
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded["id"] != first {
+		t.Errorf("expected id to survive a JSON round trip, got %s (%v)", decoded["id"], err)
+	}
+}
+
+func TestNodeIDInEnvelope(t *testing.T) {
+	net := network.NewMockNetwork()
+	aliceID := NodeIDFromKey([]byte("alice"))
+	alice, _ := NewNodeWithID(net, network.Address{IP: "127.0.0.1", Port: 8080}, aliceID)
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+
+	received := make(chan NodeID, 1)
+	bob.Handle("hello", func(msg network.Message) error {
+		id, _ := SenderID(msg)
+		received <- id
+		return nil
+	})
+
+	alice.Start()
+	bob.Start()
+	alice.SendString(bob.Address(), "hello", "Hi Bob!")
+
+	if id := <-received; id != aliceID {
+		t.Errorf("expected sender id %s, got %s", aliceID, id)
+	}
+
+	alice.Close()
+	bob.Close()
+}
@@ -2,14 +2,17 @@ package node
 
 import (
 	"github.com/ncyborgse/go-template/pkg/network"
+	log "github.com/sirupsen/logrus"
 
 	"fmt"
-	"log"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Node provides a unified abstraction for both sending and receiving messages
 type Node struct {
+	id         NodeID
 	addr       network.Address
 	network    network.Network
 	connection network.Connection
@@ -17,107 +20,332 @@ type Node struct {
 	mu         sync.RWMutex
 	closed     bool
 	closeMu    sync.RWMutex
+	logger     *log.Entry
+
+	deadLetters *DeadLetterQueue // messages that could not be processed
+	retryPolicy *RetryPolicy     // nil means Send gives up on the first failure
+	rateLimiter *RateLimiter     // nil means incoming messages are not limited
+
+	// liveness
+	pendingPings map[string]chan struct{} // nonce to waiting Ping call
+	health       map[network.Address]*PeerHealth
+	prober       *prober
+	pingMu       sync.Mutex
+
+	// streams
+	streams    map[streamKey]*Stream
+	tombstones map[streamKey]time.Time // recently finished streams
+	acceptCh   chan *Stream
+	streamMu   sync.Mutex
 }
 
 // MessageHandler is a function that processes incoming messages
 type MessageHandler func(msg network.Message) error
 
-// NewNode creates a new node that can both send and receive messages
+// NewNode creates a new node with a random id that can both send and receive messages
 func NewNode(network network.Network, addr network.Address) (*Node, error) {
+	id, err := NewRandomNodeID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
+	return NewNodeWithID(network, addr, id)
+}
+
+// NewNodeWithID creates a new node with the given id, e.g. one loaded with LoadOrCreateNodeID
+func NewNodeWithID(network network.Network, addr network.Address, id NodeID) (*Node, error) {
 	connection, err := network.Listen(addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create node: %v", err)
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
+
+	n := &Node{
+		id:          id,
+		addr:        addr,
+		network:     network,
+		connection:  connection,
+		handlers:    make(map[string]MessageHandler),
+		deadLetters: NewDeadLetterQueue(DefaultDeadLetterCapacity),
+		logger:      log.WithFields(log.Fields{"NodeID": id.Short(), "Addr": addr.String()}),
 	}
 
-	return &Node{
-		addr:       addr,
-		network:    network,
-		connection: connection,
-		handlers:   make(map[string]MessageHandler),
-	}, nil
+	// every node answers pings and accepts streams
+	n.setupPing()
+	n.setupStreams()
+
+	return n, nil
 }
 
-// Handle registers a message handler for a specific message type
+// reservedPrefix starts the message types of the node's built-in handlers
+const reservedPrefix = "node-"
+
+// Handle registers a message handler for a specific message type. Types
+// starting with "node-" are reserved for the built-in ping, pong and stream
+// handlers and cannot be replaced.
 func (n *Node) Handle(msgType string, handler MessageHandler) {
+	if strings.HasPrefix(msgType, reservedPrefix) {
+		n.Logger().WithField("MsgType", msgType).Warn("Ignoring handler for reserved message type")
+		return
+	}
+	n.handle(msgType, handler)
+}
+
+// handle registers a handler without checking for reserved types
+func (n *Node) handle(msgType string, handler MessageHandler) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.handlers[msgType] = handler
 }
 
-// Start begins listening for incoming messages
+// dispatchQueue is how many received messages may wait for their handlers
+const dispatchQueue = 100
+
+// Start begins listening for incoming messages. Messages are read and checked
+// against the rate limiter on one goroutine and handled on another, so a slow
+// handler does not keep the transport queue from being drained of floods.
 func (n *Node) Start() {
+	accepted := make(chan network.Message, dispatchQueue)
+
 	go func() {
+		for msg := range accepted {
+			n.dispatch(msg)
+		}
+	}()
+
+	go func() {
+		defer close(accepted)
 		for {
-			n.closeMu.RLock()
-			if n.closed {
-				n.closeMu.RUnlock()
+			if n.isClosed() {
 				return
 			}
-			n.closeMu.RUnlock()
 
 			msg, err := n.connection.Recv()
 			if err != nil {
-				n.closeMu.RLock()
-				if !n.closed {
-					log.Printf("Node %s failed to receive message: %v", n.addr.String(), err)
+				if !n.isClosed() {
+					n.Logger().WithError(err).Error("Failed to receive message")
 				}
-				n.closeMu.RUnlock()
 				return
 			}
 
-			// Extract message type from payload (first part before ':')
-			msgType := "default"
-			payload := string(msg.Payload)
-			if len(payload) > 0 {
-				for i, char := range payload {
-					if char == ':' {
-						msgType = payload[:i]
-						break
-					}
-				}
+			if n.admit(msg) {
+				// replies built from msg carry our id, like Send does
+				msg.ToID = n.id.String()
+				accepted <- msg
 			}
+		}
+	}()
+}
 
-			n.mu.RLock()
-			handler, exists := n.handlers[msgType]
-			if !exists {
-				handler, exists = n.handlers["default"]
-			}
-			n.mu.RUnlock()
+// admit checks a received message against the rate limiter and dead-letters
+// it if it is over a limit
+func (n *Node) admit(msg network.Message) bool {
+	n.mu.RLock()
+	rateLimiter := n.rateLimiter
+	deadLetters := n.deadLetters
+	n.mu.RUnlock()
 
-			if exists && handler != nil {
-				if err := handler(msg); err != nil {
-					log.Printf("Handler error: %v", err)
-				}
-			}
+	msgType, _ := ParsePayload(msg.Payload)
+	if rateLimiter == nil || rateLimiter.Allow(msg.From, msgType) {
+		return true
+	}
+
+	n.Logger().WithFields(log.Fields{"MsgType": msgType, "From": msg.From.String()}).Debug("Message rate limited")
+	deadLetters.Add(DeadLetter{
+		Message: msg,
+		MsgType: msgType,
+		Reason:  ReasonRateLimited,
+	})
+	return false
+}
+
+// Dispatch hands msg to the handler for its type as if it had just been
+// received, after checking it against the rate limiter. Protocols that
+// bundle messages, such as gossip batches, use it to unpack them.
+func (n *Node) Dispatch(msg network.Message) {
+	if n.admit(msg) {
+		n.dispatch(msg)
+	}
+}
+
+// dispatch hands a received message to its handler, dead-lettering it if
+// there is no handler or the handler fails
+func (n *Node) dispatch(msg network.Message) {
+	msgType, _ := ParsePayload(msg.Payload)
+
+	n.mu.RLock()
+	handler, exists := n.handlers[msgType]
+	if !exists {
+		handler, exists = n.handlers["default"]
+	}
+	deadLetters := n.deadLetters
+	n.mu.RUnlock()
+
+	if !exists || handler == nil {
+		n.Logger().WithFields(log.Fields{"MsgType": msgType, "From": msg.From.String()}).Debug("No handler for message")
+		deadLetters.Add(DeadLetter{
+			Message: msg,
+			MsgType: msgType,
+			Reason:  ReasonNoHandler,
+		})
+		return
+	}
+
+	if err := handler(msg); err != nil {
+		n.Logger().WithFields(log.Fields{"MsgType": msgType, "From": msg.From.String()}).WithError(err).Warn("Handler error")
+		deadLetters.Add(DeadLetter{
+			Message: msg,
+			MsgType: msgType,
+			Reason:  ReasonHandlerError,
+			Err:     err,
+		})
+	}
+}
+
+// ParsePayload splits a "msgType:data" payload into its message type and data.
+// Payloads without a type are reported as "default".
+func ParsePayload(payload []byte) (string, []byte) {
+	// Extract message type from payload (first part before ':')
+	for i, char := range payload {
+		if char == ':' {
+			return string(payload[:i]), payload[i+1:]
 		}
-	}()
+	}
+	return "default", payload
+}
+
+// Logger returns the structured logger used by this node
+func (n *Node) Logger() *log.Entry {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.logger
 }
 
-// Send sends a message to the target address
+// SetLogger replaces the node's logger. The node id and address are added as fields.
+func (n *Node) SetLogger(logger *log.Entry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.logger = logger.WithFields(log.Fields{"NodeID": n.id.Short(), "Addr": n.addr.String()})
+}
+
+// DeadLetters returns the queue of messages this node could not process
+func (n *Node) DeadLetters() *DeadLetterQueue {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.deadLetters
+}
+
+// SetDeadLetterQueue replaces the node's dead-letter queue, e.g. to change its
+// capacity or to share one queue between several nodes
+func (n *Node) SetDeadLetterQueue(q *DeadLetterQueue) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.deadLetters = q
+}
+
+// SetRateLimiter makes the node check incoming messages against rl before
+// dispatching them. A nil limiter disables rate limiting.
+func (n *Node) SetRateLimiter(rl *RateLimiter) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rateLimiter = rl
+}
+
+// RateLimiter returns the node's rate limiter, or nil if none is set
+func (n *Node) RateLimiter() *RateLimiter {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.rateLimiter
+}
+
+// SetRetryPolicy makes Send retry transient failures in the background
+// according to policy. A nil policy disables retries.
+func (n *Node) SetRetryPolicy(policy *RetryPolicy) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.retryPolicy = policy
+}
+
+// Send sends a message to the target address. If the attempt fails with an
+// error the retry policy covers, Send returns nil and keeps retrying in the
+// background, so handlers that reply from the receive loop never wait out a
+// backoff. Messages that still cannot be sent are dead-lettered.
 func (n *Node) Send(to network.Address, msgType string, data []byte) error {
+	err := n.sendOnce(to, msgType, data)
+	if err == nil {
+		return nil
+	}
+
+	n.mu.RLock()
+	policy := n.retryPolicy
+	n.mu.RUnlock()
+	if policy == nil || !policy.shouldRetry(err, 1) {
+		return err
+	}
+
+	// the caller may reuse data once we return
+	data = append([]byte(nil), data...)
+	n.retryLater(*policy, to, msgType, data, 1)
+	return nil
+}
+
+// SendOnce makes a single attempt to send a message and returns its error,
+// for callers that must know whether the message left, such as push-sum
+// aggregation, which keeps a share that could not be sent
+func (n *Node) SendOnce(to network.Address, msgType string, data []byte) error {
+	return n.sendOnce(to, msgType, data)
+}
+
+// retryLater makes the next attempt after the backoff for the failed attempt
+func (n *Node) retryLater(policy RetryPolicy, to network.Address, msgType string, data []byte, failed int) {
+	time.AfterFunc(policy.Backoff(failed), func() {
+		if n.isClosed() {
+			return
+		}
+
+		err := n.sendOnce(to, msgType, data)
+		if err == nil {
+			return
+		}
+		if policy.shouldRetry(err, failed+1) {
+			n.retryLater(policy, to, msgType, data, failed+1)
+			return
+		}
+
+		n.Logger().WithFields(log.Fields{"MsgType": msgType, "To": to.String(), "Attempts": failed + 1}).WithError(err).Debug("Giving up on message")
+		n.DeadLetters().Add(DeadLetter{
+			Message: network.Message{From: n.addr, To: to, FromID: n.id.String(), Payload: payload(msgType, data)},
+			MsgType: msgType,
+			Reason:  ReasonSendFailed,
+			Err:     err,
+		})
+	})
+}
+
+// sendOnce makes a single delivery attempt
+func (n *Node) sendOnce(to network.Address, msgType string, data []byte) error {
 	connection, err := n.network.Dial(to)
 	if err != nil {
-		return fmt.Errorf("failed to dial %s: %v", to.String(), err)
+		return fmt.Errorf("failed to dial %s: %w", to.String(), err)
 	}
 	defer connection.Close()
 
-	// Format payload as "msgType:data"
-	var payload []byte
-	if msgType != "" {
-		payload = append([]byte(msgType+":"), data...)
-	} else {
-		payload = data
-	}
-
 	msg := network.Message{
 		From:    n.addr,
 		To:      to,
-		Payload: payload,
+		FromID:  n.id.String(),
+		Payload: payload(msgType, data),
 	}
 
 	return connection.Send(msg)
 }
 
+// payload formats a payload as "msgType:data"
+func payload(msgType string, data []byte) []byte {
+	if msgType == "" {
+		return data
+	}
+	return append([]byte(msgType+":"), data...)
+}
+
 // SendString is a convenience method for sending string messages
 func (n *Node) SendString(to network.Address, msgType, data string) error {
 	return n.Send(to, msgType, []byte(data))
@@ -125,12 +353,27 @@ func (n *Node) SendString(to network.Address, msgType, data string) error {
 
 // Close shuts down the node
 func (n *Node) Close() error {
+	n.StopProber()
+	n.closeStreams()
+
 	n.closeMu.Lock()
 	n.closed = true
 	n.closeMu.Unlock()
 	return n.connection.Close()
 }
 
+// isClosed reports whether Close was called
+func (n *Node) isClosed() bool {
+	n.closeMu.RLock()
+	defer n.closeMu.RUnlock()
+	return n.closed
+}
+
+// ID returns the node's id
+func (n *Node) ID() NodeID {
+	return n.id
+}
+
 // Address returns the node's address
 func (n *Node) Address() network.Address {
 	return n.addr
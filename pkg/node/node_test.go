@@ -1,10 +1,14 @@
 package node
 
 import (
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/ncyborgse/go-template/pkg/network"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 )
 
 func TestNodeSimple(t *testing.T) {
@@ -24,6 +28,9 @@ func TestNodeSimple(t *testing.T) {
 	// Bob prints replies
 	bob.Handle("reply", func(msg network.Message) error {
 		fmt.Printf("Bob: %s\n", string(msg.Payload)[6:]) // Skip "reply:" prefix
+		if id, ok := SenderID(msg); !ok || id != alice.ID() {
+			t.Errorf("expected the reply to carry alice's id, got %q", msg.FromID)
+		}
 		done <- struct{}{}
 		return nil
 	})
@@ -39,3 +46,39 @@ func TestNodeSimple(t *testing.T) {
 	bob.Close()
 	fmt.Println("Done!")
 }
+
+func TestNodeLogger(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+
+	// Capture Alice's log entries
+	logger, hook := test.NewNullLogger()
+	alice.SetLogger(log.NewEntry(logger))
+
+	done := make(chan struct{})
+	alice.Handle("fail", func(msg network.Message) error {
+		defer close(done)
+		return errors.New("boom")
+	})
+
+	alice.Start()
+	bob.SendString(alice.Address(), "fail", "handler fails")
+	<-done
+
+	deadline := time.Now().Add(time.Second)
+	for hook.LastEntry() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("expected handler error to be logged")
+	}
+	if entry.Level != log.WarnLevel || entry.Data["MsgType"] != "fail" || entry.Data["NodeID"] != alice.ID().Short() {
+		t.Errorf("unexpected log entry: %v %v", entry.Level, entry.Data)
+	}
+
+	alice.Close()
+	bob.Close()
+}
@@ -0,0 +1,227 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// rttAlpha is the weight of the newest sample in the round-trip time average
+const rttAlpha = 0.2
+
+// maxProbesInFlight bounds the pings a prober waits for at the same time
+const maxProbesInFlight = 16
+
+// PeerHealth is what a node knows about the liveness of a peer
+type PeerHealth struct {
+	Addr                network.Address
+	LastSeen            time.Time     // last time the peer answered a ping
+	RTT                 time.Duration // exponentially weighted moving average of round-trip times
+	ConsecutiveFailures int           // pings without an answer since the peer was last seen
+}
+
+// prober periodically pings a set of peers
+type prober struct {
+	stop     chan struct{}
+	done     chan struct{}
+	inFlight chan struct{} // one per ping waiting for its pong
+}
+
+// setupPing registers the built-in ping and pong handlers
+func (n *Node) setupPing() {
+	n.pendingPings = make(map[string]chan struct{})
+	n.health = make(map[network.Address]*PeerHealth)
+
+	// answer pings by echoing the nonce
+	n.handle("node-ping", func(msg network.Message) error {
+		_, nonce := ParsePayload(msg.Payload)
+		return n.Send(msg.From, "node-pong", nonce)
+	})
+
+	// wake up whoever is waiting for this nonce
+	n.handle("node-pong", func(msg network.Message) error {
+		_, nonce := ParsePayload(msg.Payload)
+
+		n.pingMu.Lock()
+		waiter, exists := n.pendingPings[string(nonce)]
+		n.pingMu.Unlock()
+
+		if exists {
+			select {
+			case waiter <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	})
+}
+
+// Ping sends a ping to addr and waits for the pong, returning the round-trip time
+func (n *Node) Ping(ctx context.Context, addr network.Address) (time.Duration, error) {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	nonce := hex.EncodeToString(bytes)
+
+	waiter := make(chan struct{}, 1)
+	n.pingMu.Lock()
+	n.pendingPings[nonce] = waiter
+	n.pingMu.Unlock()
+
+	defer func() {
+		n.pingMu.Lock()
+		delete(n.pendingPings, nonce)
+		n.pingMu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := n.Send(addr, "node-ping", []byte(nonce)); err != nil {
+		n.recordPing(addr, 0, false)
+		return 0, fmt.Errorf("failed to ping %s: %w", addr.String(), err)
+	}
+
+	select {
+	case <-waiter:
+		rtt := time.Since(start)
+		n.recordPing(addr, rtt, true)
+		return rtt, nil
+	case <-ctx.Done():
+		n.recordPing(addr, 0, false)
+		return 0, ctx.Err()
+	}
+}
+
+// recordPing updates the health table with the outcome of a ping
+func (n *Node) recordPing(addr network.Address, rtt time.Duration, ok bool) {
+	n.pingMu.Lock()
+	defer n.pingMu.Unlock()
+
+	health, exists := n.health[addr]
+	if !exists {
+		health = &PeerHealth{Addr: addr}
+		n.health[addr] = health
+	}
+
+	if !ok {
+		health.ConsecutiveFailures++
+		return
+	}
+
+	if health.RTT == 0 {
+		health.RTT = rtt
+	} else {
+		health.RTT = time.Duration(rttAlpha*float64(rtt) + (1-rttAlpha)*float64(health.RTT))
+	}
+	health.LastSeen = time.Now()
+	health.ConsecutiveFailures = 0
+}
+
+// Health returns the health entry for a peer, if it was ever pinged
+func (n *Node) Health(addr network.Address) (PeerHealth, bool) {
+	n.pingMu.Lock()
+	defer n.pingMu.Unlock()
+
+	health, exists := n.health[addr]
+	if !exists {
+		return PeerHealth{}, false
+	}
+	return *health, true
+}
+
+// HealthTable returns the health entries of all pinged peers, sorted by address
+func (n *Node) HealthTable() []PeerHealth {
+	n.pingMu.Lock()
+	table := make([]PeerHealth, 0, len(n.health))
+	for _, health := range n.health {
+		table = append(table, *health)
+	}
+	n.pingMu.Unlock()
+
+	sort.Slice(table, func(i, j int) bool {
+		return table[i].Addr.String() < table[j].Addr.String()
+	})
+	return table
+}
+
+// StartProber pings every address returned by targets once per interval,
+// waiting at most timeout for each pong. At most maxProbesInFlight pings
+// wait at a time; targets beyond that are probed in a later round. The
+// health entries of addresses that are no longer targets are dropped every
+// round, so the table follows the peers as they come and go. A running
+// prober is replaced.
+func (n *Node) StartProber(interval, timeout time.Duration, targets func() []network.Address) {
+	n.StopProber()
+
+	p := &prober{
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		inFlight: make(chan struct{}, maxProbesInFlight),
+	}
+	n.pingMu.Lock()
+	n.prober = p
+	n.pingMu.Unlock()
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				addrs := targets()
+				n.keepHealth(addrs)
+				for _, addr := range addrs {
+					select {
+					case p.inFlight <- struct{}{}:
+					default:
+						n.Logger().WithField("Peer", addr.String()).Debug("Too many probes in flight, skipping peer")
+						continue
+					}
+					go func(addr network.Address) {
+						defer func() { <-p.inFlight }()
+						ctx, cancel := context.WithTimeout(context.Background(), timeout)
+						defer cancel()
+						n.Ping(ctx, addr)
+					}(addr)
+				}
+			}
+		}
+	}()
+}
+
+// keepHealth drops the health entries of every address not in addrs
+func (n *Node) keepHealth(addrs []network.Address) {
+	keep := make(map[network.Address]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+	}
+
+	n.pingMu.Lock()
+	defer n.pingMu.Unlock()
+	for addr := range n.health {
+		if !keep[addr] {
+			delete(n.health, addr)
+		}
+	}
+}
+
+// StopProber stops the background prober, if one is running
+func (n *Node) StopProber() {
+	n.pingMu.Lock()
+	p := n.prober
+	n.prober = nil
+	n.pingMu.Unlock()
+
+	if p != nil {
+		close(p.stop)
+		<-p.done
+	}
+}
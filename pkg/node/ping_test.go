@@ -0,0 +1,114 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestPing(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	alice.Start()
+	bob.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rtt, err := alice.Ping(ctx, bob.Address())
+	if err != nil {
+		t.Fatalf("expected pong, got %v", err)
+	}
+
+	health, ok := alice.Health(bob.Address())
+	if !ok || health.RTT != rtt || health.ConsecutiveFailures != 0 {
+		t.Errorf("unexpected health entry: %+v", health)
+	}
+
+	// a closed peer stops answering
+	bob.Close()
+	if _, err := alice.Ping(ctx, bob.Address()); err == nil {
+		t.Errorf("expected ping to a closed node to fail")
+	}
+	if health, _ := alice.Health(bob.Address()); health.ConsecutiveFailures != 1 {
+		t.Errorf("expected 1 consecutive failure, got %d", health.ConsecutiveFailures)
+	}
+
+	alice.Close()
+}
+
+func TestBuiltinHandlersAreReserved(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	defer alice.Close()
+	defer bob.Close()
+
+	// an application ping type of its own does not interfere, and the
+	// built-in types cannot be taken over
+	pinged := make(chan struct{}, 1)
+	bob.Handle("ping", func(msg network.Message) error {
+		pinged <- struct{}{}
+		return nil
+	})
+	bob.Handle("node-ping", func(msg network.Message) error { return nil })
+	alice.Start()
+	bob.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := alice.Ping(ctx, bob.Address()); err != nil {
+		t.Errorf("expected the built-in ping to still be answered, got %v", err)
+	}
+	alice.SendString(bob.Address(), "ping", "app")
+	select {
+	case <-pinged:
+	case <-time.After(time.Second):
+		t.Errorf("expected the application ping handler to run")
+	}
+}
+
+func TestProber(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	alice.Start()
+	bob.Start()
+
+	var mu sync.Mutex
+	targets := []network.Address{bob.Address()}
+	alice.StartProber(10*time.Millisecond, 50*time.Millisecond, func() []network.Address {
+		mu.Lock()
+		defer mu.Unlock()
+		return targets
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if health, ok := alice.Health(bob.Address()); ok && !health.LastSeen.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if table := alice.HealthTable(); len(table) != 1 || table[0].LastSeen.IsZero() {
+		t.Errorf("expected prober to record bob as alive, got %+v", table)
+	}
+
+	// bob is no longer probed, its entry goes away
+	mu.Lock()
+	targets = nil
+	mu.Unlock()
+	deadline = time.Now().Add(time.Second)
+	for len(alice.HealthTable()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if table := alice.HealthTable(); len(table) != 0 {
+		t.Errorf("expected the entry of a peer that is no longer probed to be dropped, got %+v", table)
+	}
+
+	alice.Close()
+	bob.Close()
+}
@@ -0,0 +1,163 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// maxIdleBuckets is how many per-peer buckets are kept before idle ones are swept
+const maxIdleBuckets = 1024
+
+// RateLimit describes a token bucket that refills Rate tokens per second and
+// holds at most Burst tokens. Every message consumes one token.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStats contains counters for messages dropped by a RateLimiter
+type RateLimitStats struct {
+	Allowed int
+	Dropped int
+	ByPeer  map[network.Address]int // dropped messages per sender
+	ByType  map[string]int          // dropped messages per message type
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last call
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+}
+
+// RateLimiter enforces token-bucket limits per sender address and per message type
+type RateLimiter struct {
+	mu         sync.Mutex
+	peerLimit  *RateLimit
+	typeLimits map[string]RateLimit
+	peers      map[network.Address]*tokenBucket
+	types      map[string]*tokenBucket
+
+	allowed       int
+	droppedByPeer map[network.Address]int
+	droppedByType map[string]int
+
+	now func() time.Time // clock, replaceable in tests
+}
+
+// NewRateLimiter creates a rate limiter without any limits configured
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		typeLimits:    make(map[string]RateLimit),
+		peers:         make(map[network.Address]*tokenBucket),
+		types:         make(map[string]*tokenBucket),
+		droppedByPeer: make(map[network.Address]int),
+		droppedByType: make(map[string]int),
+		now:           time.Now,
+	}
+}
+
+// SetPeerLimit limits how many messages each individual sender may deliver
+func (rl *RateLimiter) SetPeerLimit(limit RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.peerLimit = &limit
+	rl.peers = make(map[network.Address]*tokenBucket)
+}
+
+// SetTypeLimit limits how many messages of msgType are accepted from all senders combined
+func (rl *RateLimiter) SetTypeLimit(msgType string, limit RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.typeLimits[msgType] = limit
+	delete(rl.types, msgType)
+}
+
+// Allow reports whether a message of msgType from the given sender may be
+// dispatched. Tokens are only consumed if every limit allows the message, so
+// a message dropped by one limit does not use up the others.
+func (rl *RateLimiter) Allow(from network.Address, msgType string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+
+	var peerBucket, typeBucket *tokenBucket
+	if rl.peerLimit != nil {
+		bucket, exists := rl.peers[from]
+		if !exists {
+			if len(rl.peers) >= maxIdleBuckets {
+				rl.sweepIdle(now)
+			}
+			bucket = &tokenBucket{tokens: float64(rl.peerLimit.Burst), last: now}
+			rl.peers[from] = bucket
+		}
+		bucket.refill(*rl.peerLimit, now)
+		peerBucket = bucket
+	}
+
+	if limit, exists := rl.typeLimits[msgType]; exists {
+		bucket, exists := rl.types[msgType]
+		if !exists {
+			bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+			rl.types[msgType] = bucket
+		}
+		bucket.refill(limit, now)
+		typeBucket = bucket
+	}
+
+	if (peerBucket != nil && peerBucket.tokens < 1) || (typeBucket != nil && typeBucket.tokens < 1) {
+		rl.droppedByPeer[from]++
+		rl.droppedByType[msgType]++
+		return false
+	}
+	if peerBucket != nil {
+		peerBucket.tokens--
+	}
+	if typeBucket != nil {
+		typeBucket.tokens--
+	}
+
+	rl.allowed++
+	return true
+}
+
+// sweepIdle forgets peers whose buckets have refilled completely, since a
+// fresh bucket behaves the same. Must be called with rl.mu held.
+func (rl *RateLimiter) sweepIdle(now time.Time) {
+	for addr, bucket := range rl.peers {
+		elapsed := now.Sub(bucket.last).Seconds()
+		if bucket.tokens+elapsed*rl.peerLimit.Rate >= float64(rl.peerLimit.Burst) {
+			delete(rl.peers, addr)
+		}
+	}
+}
+
+// Stats returns a snapshot of the rate limiter's counters
+func (rl *RateLimiter) Stats() RateLimitStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	stats := RateLimitStats{
+		Allowed: rl.allowed,
+		ByPeer:  make(map[network.Address]int, len(rl.droppedByPeer)),
+		ByType:  make(map[string]int, len(rl.droppedByType)),
+	}
+	for addr, count := range rl.droppedByPeer {
+		stats.ByPeer[addr] = count
+		stats.Dropped += count
+	}
+	for msgType, count := range rl.droppedByType {
+		stats.ByType[msgType] = count
+	}
+	return stats
+}
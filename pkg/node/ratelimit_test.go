@@ -0,0 +1,100 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+	rl.SetPeerLimit(RateLimit{Rate: 1, Burst: 2})
+	rl.SetTypeLimit("gossip", RateLimit{Rate: 10, Burst: 3})
+
+	alice := network.Address{IP: "127.0.0.1", Port: 8080}
+	bob := network.Address{IP: "127.0.0.1", Port: 8081}
+
+	// Alice may send a burst of 2 before being throttled
+	if !rl.Allow(alice, "hello") || !rl.Allow(alice, "hello") {
+		t.Fatalf("expected burst to be allowed")
+	}
+	if rl.Allow(alice, "hello") {
+		t.Errorf("expected third message from alice to be dropped")
+	}
+
+	// Bob has a separate bucket, but shares the gossip type limit with everyone
+	if !rl.Allow(bob, "gossip") || !rl.Allow(bob, "gossip") {
+		t.Errorf("expected bob to have a separate bucket")
+	}
+	now = now.Add(2 * time.Second)
+	if !rl.Allow(alice, "gossip") {
+		t.Errorf("expected alice's bucket to refill")
+	}
+
+	stats := rl.Stats()
+	if stats.Dropped != 1 || stats.ByPeer[alice] != 1 || stats.ByType["hello"] != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRateLimiterDropKeepsOtherTokens(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+	rl.SetPeerLimit(RateLimit{Rate: 0.001, Burst: 1})
+	rl.SetTypeLimit("gossip", RateLimit{Rate: 0.001, Burst: 1})
+
+	alice := network.Address{IP: "127.0.0.1", Port: 8080}
+	bob := network.Address{IP: "127.0.0.1", Port: 8081}
+
+	// bob uses up the gossip type limit
+	if !rl.Allow(bob, "gossip") {
+		t.Fatalf("expected the first gossip message to be allowed")
+	}
+	// alice's gossip is dropped by the type limit, which must not cost her peer token
+	if rl.Allow(alice, "gossip") {
+		t.Errorf("expected the type limit to drop alice's gossip")
+	}
+	if !rl.Allow(alice, "hello") {
+		t.Errorf("expected alice to keep her token for other types")
+	}
+}
+
+func TestNodeRateLimiting(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+
+	rl := NewRateLimiter()
+	rl.SetPeerLimit(RateLimit{Rate: 0.001, Burst: 2})
+	alice.SetRateLimiter(rl)
+
+	handled := make(chan struct{}, 10)
+	alice.Handle("flood", func(msg network.Message) error {
+		handled <- struct{}{}
+		return nil
+	})
+
+	alice.Start()
+	for i := 0; i < 5; i++ {
+		bob.SendString(alice.Address(), "flood", "spam")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for alice.DeadLetters().Count(ReasonRateLimited) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(handled) != 2 {
+		t.Errorf("expected 2 messages to reach the handler, got %d", len(handled))
+	}
+	if count := alice.DeadLetters().Count(ReasonRateLimited); count != 3 {
+		t.Errorf("expected 3 rate-limited messages, got %d", count)
+	}
+
+	alice.Close()
+	bob.Close()
+}
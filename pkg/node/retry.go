@@ -0,0 +1,89 @@
+package node
+
+import (
+	"errors"
+	mathrand "math/rand"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+// RetryPolicy controls how Send retries failed deliveries
+type RetryPolicy struct {
+	MaxAttempts    int                  // total attempts including the first one, <= 1 disables retries
+	InitialBackoff time.Duration        // wait before the first retry, <= 0 means the default
+	MaxBackoff     time.Duration        // upper bound for the wait between attempts, <= 0 means none
+	Multiplier     float64              // backoff growth factor per attempt, < 1 means the default
+	Jitter         float64              // fraction (0-1) of the backoff that is randomized
+	Retryable      func(err error) bool // which errors are worth retrying, nil means IsTransient
+}
+
+// DefaultRetryPolicy returns a policy suitable for riding out short bursts of
+// full receive queues
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     200 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// IsTransient reports whether err is a network error that may go away on its own
+func IsTransient(err error) bool {
+	return errors.Is(err, network.ErrQueueFull)
+}
+
+// withDefaults replaces the fields that would make retries spin without
+// waiting with the values of DefaultRetryPolicy
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaults.InitialBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaults.Multiplier
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	return p
+}
+
+// Backoff returns how long to wait after the given failed attempt (starting at 1)
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	p = p.withDefaults()
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= p.Multiplier
+		if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		// spread the wait uniformly over [backoff*(1-jitter), backoff*(1+jitter)]
+		backoff *= 1 + p.Jitter*(2*mathrand.Float64()-1)
+	}
+
+	return time.Duration(backoff)
+}
+
+// shouldRetry reports whether a send that failed with err on the given attempt
+// should be tried again
+func (p RetryPolicy) shouldRetry(err error, attempt int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
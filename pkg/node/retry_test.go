@@ -0,0 +1,129 @@
+package node
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestSendRetriesFullQueue(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	late := make(chan struct{}, 1)
+	alice.Handle("fill", func(msg network.Message) error { return nil })
+	alice.Handle("late", func(msg network.Message) error {
+		late <- struct{}{}
+		return nil
+	})
+
+	// Alice is not started yet, so her queue fills up
+	var err error
+	for i := 0; i < 200 && err == nil; i++ {
+		err = bob.SendString(alice.Address(), "fill", "x")
+	}
+	if !errors.Is(err, network.ErrQueueFull) {
+		t.Fatalf("expected queue full error, got %v", err)
+	}
+
+	policy := RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 5 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     2,
+	}
+	bob.SetRetryPolicy(&policy)
+
+	// the retries happen in the background, so Send returns while the
+	// queue is still full
+	if err := bob.SendString(alice.Address(), "late", "x"); err != nil {
+		t.Errorf("expected send to be retried, got %v", err)
+	}
+
+	// Alice starts draining her queue while Bob is backing off
+	time.AfterFunc(20*time.Millisecond, alice.Start)
+	select {
+	case <-late:
+	case <-time.After(time.Second):
+		t.Errorf("expected the retried message to arrive")
+	}
+
+	alice.Close()
+	bob.Close()
+}
+
+func TestSendGivesUpAfterRetries(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	defer alice.Close()
+	defer bob.Close()
+
+	// Alice never drains her queue
+	for bob.SendString(alice.Address(), "fill", "x") == nil {
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	bob.SetRetryPolicy(&policy)
+	if err := bob.SendString(alice.Address(), "lost", "x"); err != nil {
+		t.Fatalf("expected send to be retried, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for bob.DeadLetters().Count(ReasonSendFailed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	entries := bob.DeadLetters().Entries()
+	if len(entries) != 1 || entries[0].MsgType != "lost" || !errors.Is(entries[0].Err, network.ErrQueueFull) {
+		t.Errorf("expected the message to be dead-lettered after the last attempt, got %+v", entries)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     30 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("attempt %d: expected backoff %v, got %v", i+1, want, got)
+		}
+	}
+
+	if policy.shouldRetry(errors.New("permanent"), 1) {
+		t.Errorf("expected non-transient errors not to be retried")
+	}
+	if policy.shouldRetry(network.ErrQueueFull, 5) {
+		t.Errorf("expected no retry after the last attempt")
+	}
+}
+
+func TestRetryPolicyBackoffDefaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{"zero initial backoff", RetryPolicy{Multiplier: 2}, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}},
+		{"negative initial backoff", RetryPolicy{InitialBackoff: -time.Second, Multiplier: 2}, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}},
+		{"zero multiplier", RetryPolicy{InitialBackoff: 5 * time.Millisecond}, []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}},
+		{"shrinking multiplier", RetryPolicy{InitialBackoff: 5 * time.Millisecond, Multiplier: 0.5}, []time.Duration{5 * time.Millisecond, 10 * time.Millisecond}},
+		{"negative max backoff", RetryPolicy{InitialBackoff: 5 * time.Millisecond, MaxBackoff: -1, Multiplier: 3}, []time.Duration{5 * time.Millisecond, 15 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				if got := tt.policy.Backoff(i + 1); got != want {
+					t.Errorf("attempt %d: expected backoff %v, got %v", i+1, want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+const (
+	streamChunkSize    = 16 * 1024              // max data bytes per frame
+	streamWindow       = 32                     // max unacknowledged frames in flight
+	streamRecvBuffer   = 1 << 20                // max unread bytes buffered per stream
+	streamRTO          = 200 * time.Millisecond // retransmission timeout
+	streamMaxRetries   = 10                     // retransmissions before giving up on the peer
+	streamAcceptQueue  = 16                     // incoming streams waiting for AcceptStream
+	streamTombstoneTTL = time.Minute            // how long closed stream ids are remembered
+	streamIdleTimeout  = time.Minute            // how long a stream without traffic is kept
+)
+
+var (
+	ErrStreamClosed  = errors.New("stream closed")
+	ErrStreamReset   = errors.New("stream reset by peer")
+	ErrStreamTimeout = errors.New("stream peer stopped acknowledging")
+)
+
+type frameKind string
+
+const (
+	frameOpen  frameKind = "open" // first frame of a stream, carries no data
+	frameData  frameKind = "data"
+	frameFin   frameKind = "fin" // last frame, the sender will not write anymore
+	frameAck   frameKind = "ack" // cumulative acknowledgement, not sequenced
+	frameReset frameKind = "rst" // abort, not sequenced
+)
+
+// streamFrame is what travels in a "node-stream" message. Open, data and fin frames
+// are sequenced and retransmitted until acknowledged.
+type streamFrame struct {
+	Stream string    `json:"stream"`
+	Kind   frameKind `json:"kind"`
+	Seq    uint64    `json:"seq,omitempty"`
+	Ack    uint64    `json:"ack,omitempty"`    // next sequence number expected by the receiver
+	Window int       `json:"window,omitempty"` // free receive buffer in bytes, in acks
+	Data   []byte    `json:"data,omitempty"`
+}
+
+type streamKey struct {
+	peer network.Address
+	id   string
+}
+
+// outFrame is a sequenced frame waiting for its acknowledgement
+type outFrame struct {
+	frame   streamFrame
+	sent    time.Time
+	retries int
+}
+
+// Stream is a reliable, ordered byte stream between two nodes, built from
+// sequenced and acknowledged frames. Every ack carries the receiver's free
+// buffer. The sender stops writing while its unacknowledged data fills it,
+// and while it is zero only probes the receiver with the oldest frame, so a
+// reader that pauses does not break the stream. A stream without traffic
+// for streamIdleTimeout is closed.
+type Stream struct {
+	node *Node
+	key  streamKey
+	mu   sync.Mutex
+	cond *sync.Cond // signalled whenever the state below changes
+
+	// sending side
+	nextSeq    uint64
+	unacked    []*outFrame // in sequence order
+	peerWindow int         // free receive buffer the peer advertised last
+	probes     int         // zero window probes since the last ack
+	probed     time.Time
+	closed     bool  // Close was called
+	err        error // terminal error
+
+	// receiving side
+	expected    uint64                 // next in-order sequence number
+	pending     map[uint64]streamFrame // frames that arrived out of order
+	buf         []byte                 // in-order data not read yet
+	finReceived bool
+	advertised  int       // window sent in the last ack
+	active      time.Time // when the last frame arrived or was written
+
+	done chan struct{} // stops the retransmission loop
+}
+
+// setupStreams registers the stream message handler
+func (n *Node) setupStreams() {
+	n.streams = make(map[streamKey]*Stream)
+	n.tombstones = make(map[streamKey]time.Time)
+	n.acceptCh = make(chan *Stream, streamAcceptQueue)
+
+	n.handle("node-stream", func(msg network.Message) error {
+		_, data := ParsePayload(msg.Payload)
+
+		var frame streamFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			return fmt.Errorf("failed to unmarshal stream frame: %v", err)
+		}
+		n.handleFrame(msg.From, frame)
+		return nil
+	})
+}
+
+// OpenStream opens a stream to the node at addr
+func (n *Node) OpenStream(to network.Address) (*Stream, error) {
+	bytes := make([]byte, 8)
+	rand.Read(bytes)
+	key := streamKey{peer: to, id: hex.EncodeToString(bytes)}
+
+	s := n.newStream(key)
+	n.streamMu.Lock()
+	n.streams[key] = s
+	n.streamMu.Unlock()
+
+	// the open frame is sequenced, so it is retransmitted like data
+	s.mu.Lock()
+	out := s.enqueue(frameOpen, nil)
+	s.mu.Unlock()
+
+	if err := n.sendFrame(to, out); err != nil && !IsTransient(err) {
+		s.finish(err)
+		return nil, fmt.Errorf("failed to open stream to %s: %w", to.String(), err)
+	}
+
+	return s, nil
+}
+
+// AcceptStream waits for a stream opened by another node
+func (n *Node) AcceptStream(ctx context.Context) (*Stream, error) {
+	select {
+	case s := <-n.acceptCh:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (n *Node) newStream(key streamKey) *Stream {
+	s := &Stream{
+		node:       n,
+		key:        key,
+		peerWindow: streamRecvBuffer,
+		advertised: streamRecvBuffer,
+		active:     time.Now(),
+		pending:    make(map[uint64]streamFrame),
+		done:       make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.retransmitLoop()
+	return s
+}
+
+func (n *Node) sendFrame(to network.Address, frame streamFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream frame: %v", err)
+	}
+	return n.Send(to, "node-stream", data)
+}
+
+// handleFrame routes an incoming frame to its stream, creating the stream
+// when a new one is opened
+func (n *Node) handleFrame(from network.Address, frame streamFrame) {
+	key := streamKey{peer: from, id: frame.Stream}
+
+	n.streamMu.Lock()
+	s, exists := n.streams[key]
+	_, closedBefore := n.tombstones[key]
+	if !exists && !closedBefore && frame.Kind == frameOpen {
+		s = n.newStream(key)
+		select {
+		case n.acceptCh <- s:
+			n.streams[key] = s
+			exists = true
+		default:
+			// nobody is accepting, refuse the stream
+			close(s.done)
+		}
+	}
+	n.streamMu.Unlock()
+
+	if !exists {
+		switch frame.Kind {
+		case frameFin:
+			// the peer is done and we already forgot the stream, just confirm
+			n.sendFrame(from, streamFrame{Stream: frame.Stream, Kind: frameAck, Ack: frame.Seq + 1, Window: streamRecvBuffer})
+		case frameOpen, frameData:
+			n.sendFrame(from, streamFrame{Stream: frame.Stream, Kind: frameReset})
+		}
+		return
+	}
+
+	switch frame.Kind {
+	case frameAck:
+		s.handleAck(frame.Ack, frame.Window)
+	case frameReset:
+		s.finish(ErrStreamReset)
+	default:
+		s.handleSequenced(frame)
+	}
+}
+
+// removeStream forgets a finished stream, remembering its id for a while so
+// late retransmissions are not mistaken for a new stream
+func (n *Node) removeStream(key streamKey) {
+	n.streamMu.Lock()
+	defer n.streamMu.Unlock()
+
+	delete(n.streams, key)
+
+	now := time.Now()
+	for k, closedAt := range n.tombstones {
+		if now.Sub(closedAt) > streamTombstoneTTL {
+			delete(n.tombstones, k)
+		}
+	}
+	n.tombstones[key] = now
+}
+
+// closeStreams aborts all streams, used when the node shuts down
+func (n *Node) closeStreams() {
+	n.streamMu.Lock()
+	streams := make([]*Stream, 0, len(n.streams))
+	for _, s := range n.streams {
+		streams = append(streams, s)
+	}
+	n.streamMu.Unlock()
+
+	for _, s := range streams {
+		s.finish(ErrStreamClosed)
+	}
+}
+
+// ID returns the stream's identifier, unique per pair of nodes
+func (s *Stream) ID() string {
+	return s.key.id
+}
+
+// RemoteAddr returns the address of the node on the other end
+func (s *Stream) RemoteAddr() network.Address {
+	return s.key.peer
+}
+
+// Write sends p in chunks, blocking while the send window is full
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + streamChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+
+		s.mu.Lock()
+		for s.windowFull() && s.err == nil && !s.closed {
+			s.cond.Wait()
+		}
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return written, err
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+
+		// copy the chunk, since it is kept around for retransmission
+		chunk := make([]byte, end-written)
+		copy(chunk, p[written:end])
+		out := s.enqueue(frameData, chunk)
+		s.mu.Unlock()
+
+		// lost frames are retransmitted by the retransmission loop
+		s.node.sendFrame(s.key.peer, out)
+		written = end
+	}
+	return written, nil
+}
+
+// Read reads in-order data, blocking until some is available. It returns
+// io.EOF once the peer has closed the stream and everything was read.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 && !s.finReceived && s.err == nil && !s.closed {
+		s.cond.Wait()
+	}
+
+	if len(s.buf) > 0 {
+		n := copy(p, s.buf)
+		s.buf = s.buf[n:]
+
+		// tell a sender that waits for room once half the buffer is free
+		free := s.window()
+		if s.advertised >= streamRecvBuffer/2 || free < streamRecvBuffer/2 {
+			s.mu.Unlock()
+			return n, nil
+		}
+		s.advertised = free
+		update := streamFrame{Stream: s.key.id, Kind: frameAck, Ack: s.expected, Window: free}
+		s.mu.Unlock()
+
+		s.node.sendFrame(s.key.peer, update)
+		return n, nil
+	}
+
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	return 0, io.EOF
+}
+
+// Close tells the peer that no more data will be written. Data already
+// written is still delivered.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed || s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	out := s.enqueue(frameFin, nil)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.node.sendFrame(s.key.peer, out)
+	return nil
+}
+
+// enqueue creates the next sequenced frame and tracks it until it is
+// acknowledged. Must be called with s.mu held.
+func (s *Stream) enqueue(kind frameKind, data []byte) streamFrame {
+	frame := streamFrame{Stream: s.key.id, Kind: kind, Seq: s.nextSeq, Data: data}
+	s.nextSeq++
+	s.unacked = append(s.unacked, &outFrame{frame: frame, sent: time.Now()})
+	s.active = time.Now()
+	return frame
+}
+
+// windowFull reports whether Write must wait for acks: too many frames are
+// in flight, or their data fills the peer's buffer. With nothing in flight
+// one frame may go out, it probes a closed window. Must be called with s.mu held.
+func (s *Stream) windowFull() bool {
+	if len(s.unacked) >= streamWindow {
+		return true
+	}
+	inflight := 0
+	for _, out := range s.unacked {
+		inflight += len(out.frame.Data)
+	}
+	return len(s.unacked) > 0 && inflight >= s.peerWindow
+}
+
+// window returns the free receive buffer. Must be called with s.mu held.
+func (s *Stream) window() int {
+	if free := streamRecvBuffer - s.buffered(); free > 0 {
+		return free
+	}
+	return 0
+}
+
+func (s *Stream) handleAck(ack uint64, window int) {
+	s.mu.Lock()
+	acked := 0
+	for acked < len(s.unacked) && s.unacked[acked].frame.Seq < ack {
+		acked++
+	}
+	s.unacked = s.unacked[acked:]
+	s.active = time.Now()
+	s.probes = 0
+	if s.peerWindow <= 0 && window > 0 {
+		// the frames were refused for lack of room, not lost
+		for _, out := range s.unacked {
+			out.retries = 0
+		}
+	}
+	s.peerWindow = window
+	finished := s.closed && len(s.unacked) == 0
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	// everything including our fin was delivered
+	if finished {
+		s.finish(nil)
+	}
+}
+
+func (s *Stream) handleSequenced(frame streamFrame) {
+	s.mu.Lock()
+	s.active = time.Now()
+
+	if frame.Seq >= s.expected && frame.Seq < s.expected+2*streamWindow && s.buffered() < streamRecvBuffer {
+		s.pending[frame.Seq] = frame
+	}
+
+	// move every frame that is now in order to the read buffer
+	for {
+		next, exists := s.pending[s.expected]
+		if !exists {
+			break
+		}
+		delete(s.pending, s.expected)
+		s.expected++
+
+		switch next.Kind {
+		case frameData:
+			s.buf = append(s.buf, next.Data...)
+		case frameFin:
+			s.finReceived = true
+		}
+	}
+	s.advertised = s.window()
+	ack := streamFrame{Stream: s.key.id, Kind: frameAck, Ack: s.expected, Window: s.advertised}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.node.sendFrame(s.key.peer, ack)
+}
+
+// buffered returns how many received bytes are waiting. Must be called with s.mu held.
+func (s *Stream) buffered() int {
+	size := len(s.buf)
+	for _, frame := range s.pending {
+		size += len(frame.Data)
+	}
+	return size
+}
+
+// retransmitLoop resends frames that were not acknowledged in time. While
+// the peer's window is zero it only probes with the oldest frame, and gives
+// up when the probes go unanswered.
+func (s *Stream) retransmitLoop() {
+	ticker := time.NewTicker(streamRTO / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		resend := make([]streamFrame, 0)
+		gaveUp := false
+		switch {
+		case len(s.unacked) == 0:
+			if time.Since(s.active) >= streamIdleTimeout {
+				s.mu.Unlock()
+				s.finishIdle()
+				return
+			}
+		case s.peerWindow <= 0:
+			if time.Since(s.probed) < streamRTO {
+				break
+			}
+			if s.probes >= streamMaxRetries {
+				gaveUp = true
+				break
+			}
+			s.probes++
+			s.probed = time.Now()
+			resend = append(resend, s.unacked[0].frame)
+		default:
+			for _, out := range s.unacked {
+				if time.Since(out.sent) < streamRTO {
+					continue
+				}
+				if out.retries >= streamMaxRetries {
+					gaveUp = true
+					break
+				}
+				out.retries++
+				out.sent = time.Now()
+				resend = append(resend, out.frame)
+			}
+		}
+		s.mu.Unlock()
+
+		if gaveUp {
+			s.finish(ErrStreamTimeout)
+			return
+		}
+		for _, frame := range resend {
+			s.node.sendFrame(s.key.peer, frame)
+		}
+	}
+}
+
+// finishIdle closes a stream without traffic. If the peer had finished
+// writing, the stream ends cleanly.
+func (s *Stream) finishIdle() {
+	s.mu.Lock()
+	finReceived := s.finReceived
+	s.mu.Unlock()
+
+	if finReceived {
+		s.finish(nil)
+		return
+	}
+	s.finish(ErrStreamTimeout)
+}
+
+// finish stops the stream, recording err as terminal error if it is not nil
+func (s *Stream) finish(err error) {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return // already finished
+	default:
+	}
+	if err != nil {
+		s.err = err
+	}
+	close(s.done)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.node.removeStream(s.key)
+}
@@ -0,0 +1,183 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+)
+
+func TestStreamTransfer(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	alice.Start()
+	bob.Start()
+
+	// a snapshot much larger than a single message
+	snapshot := make([]byte, 2<<20)
+	rand.Read(snapshot)
+
+	received := make(chan []byte, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := bob.AcceptStream(ctx)
+		if err != nil {
+			received <- nil
+			return
+		}
+		data, _ := io.ReadAll(stream)
+		stream.Close()
+		received <- data
+	}()
+
+	stream, err := alice.OpenStream(bob.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the link halfway through, lost frames must be retransmitted
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		net.Partition([]network.Address{alice.Address()}, []network.Address{bob.Address()})
+		time.Sleep(300 * time.Millisecond)
+		net.Heal()
+	}()
+
+	if _, err := stream.Write(snapshot); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	stream.Close()
+
+	select {
+	case data := <-received:
+		if !bytes.Equal(data, snapshot) {
+			t.Errorf("expected %d bytes to arrive intact, got %d bytes", len(snapshot), len(data))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the transfer")
+	}
+
+	alice.Close()
+	bob.Close()
+}
+
+func TestStreamToClosedNode(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	alice.Start()
+	bob.Close()
+
+	if _, err := alice.OpenStream(bob.Address()); err == nil {
+		t.Errorf("expected opening a stream to a closed node to fail")
+	}
+
+	alice.Close()
+}
+
+func TestStreamSurvivesPausedReader(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	alice.Start()
+	bob.Start()
+	defer alice.Close()
+	defer bob.Close()
+
+	// more than the receive buffer holds
+	data := make([]byte, 3*streamRecvBuffer)
+	rand.Read(data)
+
+	received := make(chan []byte, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := bob.AcceptStream(ctx)
+		if err != nil {
+			received <- nil
+			return
+		}
+		// pause for longer than the retransmissions would last
+		time.Sleep(streamMaxRetries*streamRTO + 500*time.Millisecond)
+		got, _ := io.ReadAll(stream)
+		stream.Close()
+		received <- got
+	}()
+
+	stream, err := alice.OpenStream(bob.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write(data); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	stream.Close()
+
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, data) {
+			t.Errorf("expected %d bytes to arrive intact, got %d bytes", len(data), len(got))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the transfer")
+	}
+
+	// both ends forget the finished stream
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && (streamCount(alice) > 0 || streamCount(bob) > 0) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if streamCount(alice) > 0 || streamCount(bob) > 0 {
+		t.Errorf("expected finished streams to be removed, alice has %d and bob %d", streamCount(alice), streamCount(bob))
+	}
+}
+
+func TestIdleStreamIsRemoved(t *testing.T) {
+	net := network.NewMockNetwork()
+	alice, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	alice.Start()
+	bob.Start()
+	defer alice.Close()
+	defer bob.Close()
+
+	if _, err := alice.OpenStream(bob.Address()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	accepted, err := bob.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pretend the last frame arrived long ago
+	time.Sleep(50 * time.Millisecond)
+	accepted.mu.Lock()
+	accepted.active = time.Now().Add(-streamIdleTimeout)
+	accepted.mu.Unlock()
+
+	if _, err := accepted.Read(make([]byte, 1)); err != ErrStreamTimeout {
+		t.Errorf("expected reading an idle stream to fail with a timeout, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && streamCount(bob) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if streamCount(bob) != 0 {
+		t.Errorf("expected the idle stream to be removed")
+	}
+}
+
+// streamCount returns how many streams n keeps
+func streamCount(n *Node) int {
+	n.streamMu.Lock()
+	defer n.streamMu.Unlock()
+	return len(n.streams)
+}
@@ -0,0 +1,284 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ncyborgse/go-template/pkg/gossip"
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+// contentType marks gossip rumors that carry a publication
+const contentType = "application/x-pubsub+json"
+
+// Mode selects how publications reach subscribers
+type Mode int
+
+const (
+	// Direct sends each publication straight to the peers that subscribed to it
+	Direct Mode = iota
+	// Gossip disseminates each publication to every node through the gossip layer
+	Gossip
+)
+
+// Handler processes a publication on a topic
+type Handler func(topic string, data []byte)
+
+// publication is what travels on the wire
+type publication struct {
+	Topic string `json:"topic"`
+	Data  []byte `json:"data"`
+}
+
+// announcement carries the complete set of patterns a node subscribes to
+type announcement struct {
+	Patterns []string `json:"patterns"`
+	Reply    bool     `json:"reply"` // true when answering another node's announcement
+}
+
+type subscription struct {
+	pattern string
+	handler Handler
+}
+
+// PubSub is a topic-based publish/subscribe layer on top of node.Node
+type PubSub struct {
+	mode   Mode
+	node   *node.Node
+	gossip *gossip.GossipNode
+	peers  func() []network.Address // peers to exchange subscriptions with in direct mode
+
+	mu     sync.RWMutex
+	subs   map[int]subscription
+	nextID int
+	remote map[network.Address][]string // patterns announced by peers
+}
+
+// NewDirect creates a pub/sub layer that exchanges subscriptions with the
+// nodes returned by peers and sends publications only to matching subscribers
+func NewDirect(n *node.Node, peers func() []network.Address) *PubSub {
+	ps := &PubSub{
+		mode:   Direct,
+		node:   n,
+		peers:  peers,
+		subs:   make(map[int]subscription),
+		remote: make(map[network.Address][]string),
+	}
+
+	n.Handle("ps-sub", ps.handleAnnouncement)
+	n.Handle("ps-pub", ps.handlePublication)
+
+	return ps
+}
+
+// NewGossip creates a pub/sub layer that gossips every publication to the
+// whole cluster, where each node delivers it to its matching subscriptions
+func NewGossip(gn *gossip.GossipNode) *PubSub {
+	ps := &PubSub{
+		mode:   Gossip,
+		gossip: gn,
+		subs:   make(map[int]subscription),
+		remote: make(map[network.Address][]string),
+	}
+
+	gn.OnDeliver(ps.handleRumor)
+
+	return ps
+}
+
+// Subscribe registers handler for all topics matching pattern and returns an
+// id that can be passed to Unsubscribe
+func (ps *PubSub) Subscribe(pattern string, handler Handler) (int, error) {
+	if err := ValidatePattern(pattern); err != nil {
+		return 0, err
+	}
+
+	ps.mu.Lock()
+	id := ps.nextID
+	ps.nextID++
+	ps.subs[id] = subscription{pattern: pattern, handler: handler}
+	ps.mu.Unlock()
+
+	// peers that miss the announcement learn about it with the next one
+	ps.Announce()
+
+	return id, nil
+}
+
+// Unsubscribe removes a subscription
+func (ps *PubSub) Unsubscribe(id int) {
+	ps.mu.Lock()
+	delete(ps.subs, id)
+	ps.mu.Unlock()
+
+	ps.Announce()
+}
+
+// Publish sends data to every subscriber of topic, including local ones
+func (ps *PubSub) Publish(topic string, data []byte) error {
+	if err := ValidateTopic(topic); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(publication{Topic: topic, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal publication: %v", err)
+	}
+
+	if ps.mode == Gossip {
+		if _, err := ps.gossip.GossipBytes(payload, contentType); err != nil {
+			return err
+		}
+		// the gossip node does not deliver its own rumors
+		ps.deliver(topic, data)
+		return nil
+	}
+
+	ps.deliver(topic, data)
+
+	var errs []error
+	for _, addr := range ps.Subscribers(topic) {
+		if err := ps.node.Send(addr, "ps-pub", payload); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish to %s: %w", addr.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Announce sends this node's subscriptions to all peers. It is a no-op in
+// gossip mode, where every node receives every publication.
+func (ps *PubSub) Announce() error {
+	if ps.mode != Direct {
+		return nil
+	}
+
+	data, err := json.Marshal(announcement{Patterns: ps.Patterns()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal announcement: %v", err)
+	}
+
+	var errs []error
+	for _, addr := range ps.peers() {
+		if err := ps.node.Send(addr, "ps-sub", data); err != nil {
+			errs = append(errs, fmt.Errorf("failed to announce to %s: %w", addr.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Patterns returns the patterns of all local subscriptions, without duplicates
+func (ps *PubSub) Patterns() []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	unique := make(map[string]bool)
+	for _, sub := range ps.subs {
+		unique[sub.pattern] = true
+	}
+
+	patterns := make([]string, 0, len(unique))
+	for pattern := range unique {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// Subscribers returns the peers that announced a pattern matching topic
+func (ps *PubSub) Subscribers(topic string) []network.Address {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	subscribers := make([]network.Address, 0)
+	for addr, patterns := range ps.remote {
+		for _, pattern := range patterns {
+			if Match(pattern, topic) {
+				subscribers = append(subscribers, addr)
+				break
+			}
+		}
+	}
+	return subscribers
+}
+
+// deliver hands a publication to all matching local subscriptions
+func (ps *PubSub) deliver(topic string, data []byte) {
+	ps.mu.RLock()
+	handlers := make([]Handler, 0)
+	for _, sub := range ps.subs {
+		if Match(sub.pattern, topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	ps.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(topic, data)
+	}
+}
+
+func (ps *PubSub) handleAnnouncement(msg network.Message) error {
+	_, data := node.ParsePayload(msg.Payload)
+
+	var ann announcement
+	if err := json.Unmarshal(data, &ann); err != nil {
+		return fmt.Errorf("failed to unmarshal announcement: %v", err)
+	}
+	for _, pattern := range ann.Patterns {
+		if err := ValidatePattern(pattern); err != nil {
+			return fmt.Errorf("rejected announcement from %s: %v", msg.From.String(), err)
+		}
+	}
+
+	ps.mu.Lock()
+	if len(ann.Patterns) == 0 {
+		delete(ps.remote, msg.From)
+	} else {
+		ps.remote[msg.From] = ann.Patterns
+	}
+	ps.mu.Unlock()
+
+	if ann.Reply {
+		return nil
+	}
+
+	// let the announcing node know about our subscriptions too
+	reply, err := json.Marshal(announcement{Patterns: ps.Patterns(), Reply: true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal announcement: %v", err)
+	}
+	return ps.node.Send(msg.From, "ps-sub", reply)
+}
+
+func (ps *PubSub) handlePublication(msg network.Message) error {
+	_, data := node.ParsePayload(msg.Payload)
+
+	var pub publication
+	if err := json.Unmarshal(data, &pub); err != nil {
+		return fmt.Errorf("failed to unmarshal publication: %v", err)
+	}
+
+	ps.deliver(pub.Topic, pub.Data)
+	return nil
+}
+
+func (ps *PubSub) handleRumor(msg gossip.GossipMessage) {
+	if msg.ContentType != contentType {
+		return // an ordinary rumor
+	}
+
+	data, err := msg.Data()
+	if err != nil {
+		return
+	}
+	var pub publication
+	if err := json.Unmarshal(data, &pub); err != nil {
+		return
+	}
+
+	ps.deliver(pub.Topic, pub.Data)
+}
@@ -0,0 +1,176 @@
+package pubsub
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/gossip"
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+// recorder collects publications delivered to a handler
+type recorder struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func (r *recorder) handle(topic string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics = append(r.topics, topic)
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.topics)
+}
+
+func waitFor(condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"metrics.cpu", "metrics.cpu", true},
+		{"config.*", "config.db", true},
+		{"config.*", "config.db.host", false},
+		{"config.**", "config.db.host", true},
+		{"config.**", "config", true},
+		{"*.cpu", "metrics.cpu", true},
+		{"metrics.cpu", "metrics.mem", false},
+		{"**", "", true},
+		{"a.**.b.**.c", "a.x.b.y.z.c", true},
+		{"a.**.b.**.c", "a.x.c.y.b", false},
+		{"**.*", "metrics", true},
+		{"**.*.*", "metrics", false},
+	}
+
+	for _, c := range cases {
+		if Match(c.pattern, c.topic) != c.match {
+			t.Errorf("Match(%q, %q) should be %v", c.pattern, c.topic, c.match)
+		}
+	}
+
+	if ValidatePattern("config.d*") == nil {
+		t.Errorf("expected partial-segment wildcard to be rejected")
+	}
+
+	// backtracking would take ages on this one
+	pattern := strings.Repeat("**.", 30) + "x"
+	topic := strings.Repeat("a.", 60) + "b"
+	done := make(chan bool, 1)
+	go func() { done <- Match(pattern, topic) }()
+	select {
+	case matched := <-done:
+		if matched {
+			t.Errorf("expected %q not to match", topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("matching a pattern with many ** segments took too long")
+	}
+}
+
+func TestDirectPubSub(t *testing.T) {
+	net := network.NewMockNetwork()
+	addrs := []network.Address{
+		{IP: "127.0.0.1", Port: 8080},
+		{IP: "127.0.0.1", Port: 8081},
+		{IP: "127.0.0.1", Port: 8082},
+	}
+
+	nodes := make([]*PubSub, len(addrs))
+	for i, addr := range addrs {
+		n, _ := node.NewNode(net, addr)
+		n.Start()
+		defer n.Close()
+
+		self := addr
+		nodes[i] = NewDirect(n, func() []network.Address {
+			peers := make([]network.Address, 0)
+			for _, other := range addrs {
+				if other != self {
+					peers = append(peers, other)
+				}
+			}
+			return peers
+		})
+	}
+
+	cpu, config := &recorder{}, &recorder{}
+	nodes[0].Subscribe("metrics.*", cpu.handle)
+	nodes[1].Subscribe("config.**", config.handle)
+
+	// wait until the publisher has learned both subscriptions
+	waitFor(func() bool {
+		return len(nodes[2].Subscribers("metrics.cpu")) == 1 && len(nodes[2].Subscribers("config.db.host")) == 1
+	})
+
+	nodes[2].Publish("metrics.cpu", []byte("42"))
+	nodes[2].Publish("config.db.host", []byte("db1"))
+	nodes[2].Publish("logs.error", []byte("ignored"))
+
+	waitFor(func() bool { return cpu.count() == 1 && config.count() == 1 })
+	if cpu.count() != 1 || config.count() != 1 {
+		t.Errorf("expected one publication per subscriber, got %d and %d", cpu.count(), config.count())
+	}
+}
+
+func TestInvalidAnnouncementIsRejected(t *testing.T) {
+	net := network.NewMockNetwork()
+	n, _ := node.NewNode(net, network.Address{IP: "127.0.0.1", Port: 8080})
+	remote, _ := node.NewNode(net, network.Address{IP: "127.0.0.1", Port: 8081})
+	n.Start()
+	remote.Start()
+	defer n.Close()
+	defer remote.Close()
+
+	ps := NewDirect(n, func() []network.Address { return nil })
+	remote.SendString(n.Address(), "ps-sub", `{"patterns":["metrics.*","metrics.c*"]}`)
+
+	waitFor(func() bool { return n.DeadLetters().Count(node.ReasonHandlerError) == 1 })
+	if got := ps.Subscribers("metrics.cpu"); len(got) != 0 {
+		t.Errorf("expected the invalid announcement to be ignored, got subscribers %v", got)
+	}
+}
+
+func TestGossipPubSub(t *testing.T) {
+	net := network.NewMockNetwork()
+	builder := gossip.NewNetworkBuilder(net, gossip.DefaultGossipConfig())
+	if err := builder.CreateNodes(5); err != nil {
+		t.Fatal(err)
+	}
+
+	// fully connected, so every node is reachable
+	nodes := builder.GetNodes()
+	for _, gn := range nodes {
+		for _, peer := range nodes {
+			gn.AddPeer(peer.Address())
+		}
+	}
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	received := &recorder{}
+	layers := make([]*PubSub, len(nodes))
+	for i, gn := range nodes {
+		layers[i] = NewGossip(gn)
+		layers[i].Subscribe("config.*", received.handle)
+	}
+
+	layers[0].Publish("config.flags", []byte("dark-mode=on"))
+
+	waitFor(func() bool { return received.count() == len(nodes) })
+	if received.count() != len(nodes) {
+		t.Errorf("expected every node to deliver the publication once, got %d deliveries", received.count())
+	}
+}
@@ -0,0 +1,68 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topics are dot-separated names such as "metrics.cpu". Patterns use the same
+// syntax, where "*" matches exactly one segment and "**" matches any number
+// of segments (including none), e.g. "config.*" or "metrics.**".
+
+// ValidateTopic checks that a topic is non-empty and contains no wildcards
+func ValidateTopic(topic string) error {
+	for _, segment := range strings.Split(topic, ".") {
+		if segment == "" {
+			return fmt.Errorf("invalid topic %q: empty segment", topic)
+		}
+		if strings.Contains(segment, "*") {
+			return fmt.Errorf("invalid topic %q: wildcards are only allowed in patterns", topic)
+		}
+	}
+	return nil
+}
+
+// ValidatePattern checks that a pattern is non-empty and only uses whole-segment wildcards
+func ValidatePattern(pattern string) error {
+	for _, segment := range strings.Split(pattern, ".") {
+		if segment == "" {
+			return fmt.Errorf("invalid pattern %q: empty segment", pattern)
+		}
+		if strings.Contains(segment, "*") && segment != "*" && segment != "**" {
+			return fmt.Errorf("invalid pattern %q: wildcards must span a whole segment", pattern)
+		}
+	}
+	return nil
+}
+
+// Match reports whether topic matches pattern
+func Match(pattern, topic string) bool {
+	return matchSegments(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+// matchSegments matches from the last segments backwards, keeping for every
+// position in the topic whether the rest of the pattern matches the rest of
+// the topic. It takes time proportional to the product of the lengths, also
+// for patterns with many "**" segments.
+func matchSegments(pattern, topic []string) bool {
+	// next[j] reports whether pattern[i+1:] matches topic[j:]
+	next := make([]bool, len(topic)+1)
+	next[len(topic)] = true
+	current := make([]bool, len(topic)+1)
+
+	for i := len(pattern) - 1; i >= 0; i-- {
+		for j := len(topic); j >= 0; j-- {
+			switch {
+			case pattern[i] == "**":
+				// swallow no segment, or one more
+				current[j] = next[j] || (j < len(topic) && current[j+1])
+			case j == len(topic):
+				current[j] = false
+			default:
+				current[j] = (pattern[i] == "*" || pattern[i] == topic[j]) && next[j+1]
+			}
+		}
+		next, current = current, next
+	}
+	return next[0]
+}
@@ -0,0 +1,662 @@
+package swim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+	log "github.com/sirupsen/logrus"
+)
+
+// State is a member's liveness as seen by this node
+type State int
+
+const (
+	Alive State = iota
+	Suspect
+	Dead
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// Member is an entry in the membership list
+type Member struct {
+	Addr        network.Address
+	ID          node.NodeID
+	State       State
+	Incarnation uint64    // only the member itself increments this, to refute suspicion
+	StateChange time.Time // when State last changed locally
+}
+
+// EventType describes a membership change
+type EventType int
+
+const (
+	MemberJoined EventType = iota
+	MemberSuspected
+	MemberRecovered // a suspected member refuted the suspicion
+	MemberDead
+)
+
+// Event is passed to handlers registered with OnChange
+type Event struct {
+	Type   EventType
+	Member Member
+}
+
+// EventHandler is called for every membership change
+type EventHandler func(Event)
+
+// Sender sends messages to other nodes, such as node.Node or a
+// gossip.GossipNode that batches them with its rumors
+type Sender interface {
+	Send(to network.Address, msgType string, data []byte) error
+}
+
+// PeerSet is anything that keeps a list of peers, such as gossip.GossipNode
+type PeerSet interface {
+	AddPeer(addr network.Address)
+	RemovePeer(addr network.Address)
+}
+
+// Config contains the SWIM protocol parameters
+type Config struct {
+	ProbeInterval    time.Duration // length of a protocol period
+	ProbeTimeout     time.Duration // how long to wait for a direct ack
+	IndirectProbes   int           // helpers asked to probe a member that did not answer
+	SuspicionTimeout time.Duration // how long a member stays suspect before it is declared dead
+	RetransmitMult   int           // each update is piggybacked RetransmitMult*log(n+1) times
+	MaxPiggyback     int           // maximum number of updates per message
+}
+
+// DefaultConfig returns parameters suitable for a local cluster
+func DefaultConfig() Config {
+	return Config{
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     300 * time.Millisecond,
+		IndirectProbes:   3,
+		SuspicionTimeout: 5 * time.Second,
+		RetransmitMult:   4,
+		MaxPiggyback:     8,
+	}
+}
+
+// update is a membership change that is disseminated by piggybacking it on
+// pings, acks and, with a gossip node, on rumors
+type update struct {
+	Addr        network.Address `json:"addr"`
+	ID          node.NodeID     `json:"id"`
+	State       State           `json:"state"`
+	Incarnation uint64          `json:"incarnation"`
+	Join        bool            `json:"join,omitempty"` // announced by Join, possibly after a restart
+}
+
+// queuedUpdate counts how often an update was piggybacked
+type queuedUpdate struct {
+	update    update
+	transmits int
+}
+
+// message is the body of every SWIM message
+type message struct {
+	Seq     uint64          `json:"seq"`
+	Target  network.Address `json:"target,omitempty"` // member to probe, ping-req only
+	Updates []update        `json:"updates,omitempty"`
+}
+
+// Memberlist maintains the cluster membership using the SWIM protocol:
+// randomized probing, indirect probes through helpers, suspicion with
+// incarnation numbers, and infection-style dissemination of changes on
+// SWIM messages and gossip rumors
+type Memberlist struct {
+	node   *node.Node
+	sender Sender
+	config Config
+
+	mu          sync.Mutex
+	incarnation uint64
+	members     map[network.Address]*Member // everyone except ourselves, including dead members
+	probeOrder  []network.Address
+	probeIndex  int
+	seq         uint64
+	acks        map[uint64]chan struct{} // seq to waiting probe
+	updates     map[network.Address]*queuedUpdate
+	handlers    []EventHandler
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New creates a membership list on top of n. Call Join and Start to take part.
+func New(n *node.Node, config Config) *Memberlist {
+	m := &Memberlist{
+		node:    n,
+		sender:  n,
+		config:  config,
+		members: make(map[network.Address]*Member),
+		acks:    make(map[uint64]chan struct{}),
+		updates: make(map[network.Address]*queuedUpdate),
+		stop:    make(chan struct{}),
+	}
+
+	n.Handle("swim-ping", m.handlePing)
+	n.Handle("swim-ping-req", m.handlePingReq)
+	n.Handle("swim-ack", m.handleAck)
+
+	return m
+}
+
+// OnChange registers a handler for membership changes
+func (m *Memberlist) OnChange(handler EventHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+// SetSender sends the SWIM messages through s instead of the node, for
+// example a gossip node that batches them. Batching delays probes, so the
+// batch interval must stay well below the probe timeout. It must be called
+// before Join and Start.
+func (m *Memberlist) SetSender(s Sender) {
+	m.sender = s
+}
+
+// Bind keeps peers in sync with the membership list: members are added when
+// they join and removed when they are declared dead
+func (m *Memberlist) Bind(peers PeerSet) {
+	for _, member := range m.Members() {
+		peers.AddPeer(member.Addr)
+	}
+
+	m.OnChange(func(event Event) {
+		switch event.Type {
+		case MemberJoined:
+			peers.AddPeer(event.Member.Addr)
+		case MemberDead:
+			peers.RemovePeer(event.Member.Addr)
+		}
+	})
+}
+
+// Join contacts the seed nodes. Their acks carry the rest of the membership.
+// A node that restarts with the same address and id joins again at
+// incarnation 0; members that declared it dead answer with their record,
+// which it refutes like any other suspicion.
+func (m *Memberlist) Join(seeds []network.Address) {
+	m.mu.Lock()
+	// announce ourselves with the next pings
+	m.queue(update{Addr: m.node.Address(), ID: m.node.ID(), State: Alive, Incarnation: m.incarnation, Join: true})
+	m.mu.Unlock()
+
+	for _, seed := range seeds {
+		if seed == m.node.Address() {
+			continue
+		}
+		go m.probe(seed)
+	}
+}
+
+// Start runs the protocol until Stop is called
+func (m *Memberlist) Start() {
+	go func() {
+		ticker := time.NewTicker(m.config.ProbeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.expireSuspects()
+				if target, ok := m.nextProbeTarget(); ok {
+					go m.probe(target)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops probing. The node keeps answering pings until it is closed.
+func (m *Memberlist) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// Members returns all members that are not known to be dead, sorted by address
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	members := make([]Member, 0, len(m.members))
+	for _, member := range m.members {
+		if member.State != Dead {
+			members = append(members, *member)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Addr.String() < members[j].Addr.String()
+	})
+	return members
+}
+
+// Member returns the entry for addr, including dead members
+func (m *Memberlist) Member(addr network.Address) (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	member, exists := m.members[addr]
+	if !exists {
+		return Member{}, false
+	}
+	return *member, true
+}
+
+// nextProbeTarget walks the members in a random order, reshuffling after
+// every full pass, so every member is probed within a bounded time
+func (m *Memberlist) nextProbeTarget() (network.Address, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for attempts := 0; attempts < 2; attempts++ {
+		for m.probeIndex < len(m.probeOrder) {
+			addr := m.probeOrder[m.probeIndex]
+			m.probeIndex++
+			if member, exists := m.members[addr]; exists && member.State != Dead {
+				return addr, true
+			}
+		}
+
+		m.probeOrder = m.probeOrder[:0]
+		for addr, member := range m.members {
+			if member.State != Dead {
+				m.probeOrder = append(m.probeOrder, addr)
+			}
+		}
+		mathrand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+		m.probeIndex = 0
+	}
+	return network.Address{}, false
+}
+
+// probe pings target directly, then through helpers, and suspects it if
+// nobody got an ack within the protocol period
+func (m *Memberlist) probe(target network.Address) {
+	seq, acked := m.expectAck()
+	defer m.forgetAck(seq)
+
+	m.send(target, "swim-ping", message{Seq: seq})
+
+	select {
+	case <-acked:
+		return
+	case <-time.After(m.config.ProbeTimeout):
+	case <-m.stop:
+		return
+	}
+
+	// ask k other members to probe the target for us
+	for _, helper := range m.randomMembers(m.config.IndirectProbes, target) {
+		m.send(helper, "swim-ping-req", message{Seq: seq, Target: target})
+	}
+
+	remaining := m.config.ProbeInterval - m.config.ProbeTimeout
+	if remaining < m.config.ProbeTimeout {
+		remaining = m.config.ProbeTimeout
+	}
+	select {
+	case <-acked:
+		return
+	case <-time.After(remaining):
+	case <-m.stop:
+		return
+	}
+
+	m.suspect(target)
+}
+
+// expectAck allocates a sequence number and a channel that is signalled when its ack arrives
+func (m *Memberlist) expectAck() (uint64, chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	acked := make(chan struct{}, 1)
+	m.acks[m.seq] = acked
+	return m.seq, acked
+}
+
+func (m *Memberlist) forgetAck(seq uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.acks, seq)
+}
+
+// send piggybacks pending updates on msg and sends it
+func (m *Memberlist) send(to network.Address, msgType string, msg message) {
+	msg.Updates = m.piggyback()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		m.node.Logger().WithError(err).Error("Failed to marshal SWIM message")
+		return
+	}
+	if err := m.sender.Send(to, msgType, data); err != nil {
+		m.node.Logger().WithFields(log.Fields{"MsgType": msgType, "Peer": to.String()}).WithError(err).Debug("SWIM send failed")
+	}
+}
+
+// receive decodes a SWIM message and applies its piggybacked updates
+func (m *Memberlist) receive(msg network.Message) (message, error) {
+	_, data := node.ParsePayload(msg.Payload)
+
+	var body message
+	if err := json.Unmarshal(data, &body); err != nil {
+		return message{}, fmt.Errorf("failed to unmarshal SWIM message: %v", err)
+	}
+	for _, u := range body.Updates {
+		m.apply(u)
+	}
+
+	// hearing from a node we do not know yet, such as the seed we joined
+	// through, is proof enough that it is alive
+	if id, ok := node.SenderID(msg); ok {
+		m.mu.Lock()
+		_, known := m.members[msg.From]
+		m.mu.Unlock()
+		if !known {
+			m.apply(update{Addr: msg.From, ID: id, State: Alive})
+		}
+	}
+	return body, nil
+}
+
+func (m *Memberlist) handlePing(msg network.Message) error {
+	body, err := m.receive(msg)
+	if err != nil {
+		return err
+	}
+	m.send(msg.From, "swim-ack", message{Seq: body.Seq})
+	return nil
+}
+
+func (m *Memberlist) handlePingReq(msg network.Message) error {
+	body, err := m.receive(msg)
+	if err != nil {
+		return err
+	}
+
+	// probe the target on behalf of the requester and relay a successful ack
+	go func() {
+		seq, acked := m.expectAck()
+		defer m.forgetAck(seq)
+
+		m.send(body.Target, "swim-ping", message{Seq: seq})
+		select {
+		case <-acked:
+			m.send(msg.From, "swim-ack", message{Seq: body.Seq})
+		case <-time.After(m.config.ProbeTimeout):
+		case <-m.stop:
+		}
+	}()
+	return nil
+}
+
+func (m *Memberlist) handleAck(msg network.Message) error {
+	body, err := m.receive(msg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	acked, exists := m.acks[body.Seq]
+	m.mu.Unlock()
+
+	if exists {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// suspect marks a member that did not answer as suspect
+func (m *Memberlist) suspect(addr network.Address) {
+	m.mu.Lock()
+	member, exists := m.members[addr]
+	if !exists || member.State != Alive {
+		m.mu.Unlock()
+		return
+	}
+	u := update{Addr: addr, ID: member.ID, State: Suspect, Incarnation: member.Incarnation}
+	m.mu.Unlock()
+
+	m.apply(u)
+}
+
+// expireSuspects declares members dead that stayed suspect for too long
+func (m *Memberlist) expireSuspects() {
+	m.mu.Lock()
+	expired := make([]update, 0)
+	for addr, member := range m.members {
+		if member.State == Suspect && time.Since(member.StateChange) > m.config.SuspicionTimeout {
+			expired = append(expired, update{Addr: addr, ID: member.ID, State: Dead, Incarnation: member.Incarnation})
+		}
+	}
+	m.mu.Unlock()
+
+	for _, u := range expired {
+		m.apply(u)
+	}
+}
+
+// apply merges a membership update using the SWIM precedence rules and
+// queues it for further dissemination if it changed anything
+func (m *Memberlist) apply(u update) {
+	m.mu.Lock()
+
+	if u.Addr == m.node.Address() {
+		// somebody thinks we are suspect or dead, refute it with a higher incarnation
+		if u.State != Alive && u.Incarnation >= m.incarnation {
+			m.incarnation = u.Incarnation + 1
+			m.queue(update{Addr: u.Addr, ID: m.node.ID(), State: Alive, Incarnation: m.incarnation})
+		}
+		m.mu.Unlock()
+		return
+	}
+
+	member, exists := m.members[u.Addr]
+	changed := false // the update is news and must be disseminated
+	notify := false  // the change is visible to event handlers
+	var event Event
+
+	switch {
+	case !exists:
+		if u.State == Dead {
+			break // never heard of it, nothing to forget
+		}
+		member = &Member{Addr: u.Addr, ID: u.ID, State: u.State, Incarnation: u.Incarnation, StateChange: time.Now()}
+		m.members[u.Addr] = member
+		changed, notify = true, true
+		event.Type = MemberJoined
+
+	case u.ID != member.ID && u.State == Alive && (u.Join || member.State == Dead):
+		// a new node took over the address, its incarnations start over
+		member.ID = u.ID
+		member.State = Alive
+		member.Incarnation = u.Incarnation
+		member.StateChange = time.Now()
+		changed, notify = true, true
+		event.Type = MemberJoined
+
+	case u.ID != member.ID:
+		// news about a previous node at this address, or about a new one
+		// that this node has not accepted yet
+
+	case u.Join && member.State == Dead && u.Incarnation <= member.Incarnation:
+		// a restarted node does not know it was declared dead. Spread the
+		// record again so that it reaches the node and is refuted with a
+		// higher incarnation.
+		m.queue(update{Addr: member.Addr, ID: member.ID, State: Dead, Incarnation: member.Incarnation})
+
+	case u.State == Alive && u.Incarnation > member.Incarnation:
+		previous := member.State
+		member.ID = u.ID
+		member.State = Alive
+		member.Incarnation = u.Incarnation
+		member.StateChange = time.Now()
+		changed = true
+		switch previous {
+		case Suspect:
+			notify = true
+			event.Type = MemberRecovered
+		case Dead:
+			notify = true
+			event.Type = MemberJoined
+		}
+
+	case u.State == Suspect && member.State != Dead &&
+		((member.State == Alive && u.Incarnation >= member.Incarnation) || u.Incarnation > member.Incarnation):
+		notify = member.State == Alive
+		member.State = Suspect
+		member.Incarnation = u.Incarnation
+		member.StateChange = time.Now()
+		changed = true
+		event.Type = MemberSuspected
+
+	case u.State == Dead && member.State != Dead && u.Incarnation >= member.Incarnation:
+		member.State = Dead
+		member.Incarnation = u.Incarnation
+		member.StateChange = time.Now()
+		changed, notify = true, true
+		event.Type = MemberDead
+	}
+
+	if !changed {
+		m.mu.Unlock()
+		return
+	}
+
+	m.queue(u)
+	event.Member = *member
+	handlers := m.handlers
+	m.mu.Unlock()
+
+	if !notify {
+		return
+	}
+
+	m.node.Logger().WithFields(log.Fields{
+		"Member":      u.Addr.String(),
+		"State":       event.Member.State.String(),
+		"Incarnation": event.Member.Incarnation,
+	}).Debug("Membership changed")
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// queue schedules an update for piggybacking, replacing older news about
+// the same member. Must be called with m.mu held.
+func (m *Memberlist) queue(u update) {
+	m.updates[u.Addr] = &queuedUpdate{update: u}
+}
+
+// piggyback picks the least transmitted updates for the next message
+func (m *Memberlist) piggyback() []update {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queued := make([]*queuedUpdate, 0, len(m.updates))
+	for _, q := range m.updates {
+		queued = append(queued, q)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].transmits < queued[j].transmits
+	})
+
+	limit := m.config.RetransmitMult * int(math.Ceil(math.Log(float64(len(m.members)+2))))
+	updates := make([]update, 0, m.config.MaxPiggyback)
+	for _, q := range queued {
+		if len(updates) == m.config.MaxPiggyback {
+			break
+		}
+		updates = append(updates, q.update)
+		q.transmits++
+		if q.transmits >= limit {
+			delete(m.updates, q.update.Addr)
+		}
+	}
+	return updates
+}
+
+// Piggyback returns pending updates for a gossip node to attach to the
+// next rumor it pushes, nil if there are none. Together with
+// ReceivePiggyback it implements gossip.Piggybacker, so that membership
+// changes also ride on the rumors:
+//
+//	gn.SetPiggybacker(list)
+func (m *Memberlist) Piggyback() []byte {
+	updates := m.piggyback()
+	if len(updates) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(updates)
+	if err != nil {
+		m.node.Logger().WithError(err).Error("Failed to marshal SWIM updates")
+		return nil
+	}
+	return data
+}
+
+// ReceivePiggyback applies the updates attached to a rumor
+func (m *Memberlist) ReceivePiggyback(data []byte, from network.Address) error {
+	var updates []update
+	if err := json.Unmarshal(data, &updates); err != nil {
+		return fmt.Errorf("failed to unmarshal SWIM updates from %s: %v", from.String(), err)
+	}
+	for _, u := range updates {
+		m.apply(u)
+	}
+	return nil
+}
+
+// randomMembers returns up to k live members other than exclude
+func (m *Memberlist) randomMembers(k int, exclude network.Address) []network.Address {
+	m.mu.Lock()
+	candidates := make([]network.Address, 0, len(m.members))
+	for addr, member := range m.members {
+		if addr != exclude && member.State == Alive {
+			candidates = append(candidates, addr)
+		}
+	}
+	m.mu.Unlock()
+
+	mathrand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
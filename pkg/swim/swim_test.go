@@ -0,0 +1,237 @@
+package swim
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncyborgse/go-template/pkg/gossip"
+	"github.com/ncyborgse/go-template/pkg/network"
+	"github.com/ncyborgse/go-template/pkg/node"
+)
+
+func testConfig() Config {
+	return Config{
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     8 * time.Millisecond,
+		IndirectProbes:   2,
+		SuspicionTimeout: 100 * time.Millisecond,
+		RetransmitMult:   4,
+		MaxPiggyback:     8,
+	}
+}
+
+// peerSet records AddPeer and RemovePeer calls
+type peerSet struct {
+	mu    sync.Mutex
+	peers map[network.Address]bool
+}
+
+func (p *peerSet) AddPeer(addr network.Address) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers[addr] = true
+}
+
+func (p *peerSet) RemovePeer(addr network.Address) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.peers, addr)
+}
+
+func (p *peerSet) has(addr network.Address) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peers[addr]
+}
+
+func startCluster(t *testing.T, net network.Network, size int) ([]*node.Node, []*Memberlist) {
+	nodes := make([]*node.Node, size)
+	lists := make([]*Memberlist, size)
+	for i := 0; i < size; i++ {
+		n, err := node.NewNode(net, network.Address{IP: "127.0.0.1", Port: 9000 + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+		lists[i] = New(n, testConfig())
+		n.Start()
+		lists[i].Start()
+	}
+
+	// everyone joins through the first node
+	for i := 1; i < size; i++ {
+		lists[i].Join([]network.Address{nodes[0].Address()})
+	}
+	return nodes, lists
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMembershipConverges(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, lists := startCluster(t, net, 5)
+
+	waitFor(t, 5*time.Second, "full membership", func() bool {
+		for _, list := range lists {
+			if len(list.Members()) != len(nodes)-1 {
+				return false
+			}
+		}
+		return true
+	})
+
+	for i, list := range lists {
+		for _, member := range list.Members() {
+			if member.State != Alive {
+				t.Errorf("node %d sees %s as %s", i, member.Addr.String(), member.State)
+			}
+		}
+		list.Stop()
+		nodes[i].Close()
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, lists := startCluster(t, net, 5)
+
+	peers := &peerSet{peers: make(map[network.Address]bool)}
+	lists[0].Bind(peers)
+
+	victim := nodes[4].Address()
+	waitFor(t, 5*time.Second, "full membership", func() bool {
+		return len(lists[0].Members()) == len(nodes)-1 && peers.has(victim)
+	})
+
+	// crash the last node, every survivor must eventually declare it dead
+	lists[4].Stop()
+	nodes[4].Close()
+
+	waitFor(t, 5*time.Second, "the crashed node to be declared dead", func() bool {
+		for _, list := range lists[:4] {
+			member, exists := list.Member(victim)
+			if !exists || member.State != Dead {
+				return false
+			}
+		}
+		return true
+	})
+
+	if peers.has(victim) {
+		t.Errorf("expected the dead node to be removed from the bound peer set")
+	}
+
+	for i := 0; i < 4; i++ {
+		lists[i].Stop()
+		nodes[i].Close()
+	}
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, lists := startCluster(t, net, 3)
+
+	waitFor(t, 5*time.Second, "full membership", func() bool {
+		return len(lists[0].Members()) == 2 && len(lists[2].Members()) == 2
+	})
+
+	// node 0 wrongly suspects node 2, which must refute with a higher incarnation
+	suspected, _ := lists[0].Member(nodes[2].Address())
+	lists[0].suspect(nodes[2].Address())
+
+	waitFor(t, 5*time.Second, "the suspicion to be refuted", func() bool {
+		member, _ := lists[0].Member(nodes[2].Address())
+		return member.State == Alive && member.Incarnation > suspected.Incarnation
+	})
+
+	for i := range lists {
+		lists[i].Stop()
+		nodes[i].Close()
+	}
+}
+
+func TestRestartedMemberRejoins(t *testing.T) {
+	net := network.NewMockNetwork()
+	nodes, lists := startCluster(t, net, 3)
+
+	victim := nodes[2].Address()
+	waitFor(t, 5*time.Second, "full membership", func() bool {
+		return len(lists[0].Members()) == 2 && len(lists[1].Members()) == 2
+	})
+
+	lists[2].Stop()
+	nodes[2].Close()
+	waitFor(t, 5*time.Second, "the crashed node to be declared dead", func() bool {
+		a, _ := lists[0].Member(victim)
+		b, _ := lists[1].Member(victim)
+		return a.State == Dead && b.State == Dead
+	})
+	dead, _ := lists[0].Member(victim)
+
+	// the node comes back with its address and id but starts at incarnation 0
+	restarted, err := node.NewNodeWithID(net, victim, nodes[2].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := New(restarted, testConfig())
+	restarted.Start()
+	list.Start()
+	list.Join([]network.Address{nodes[0].Address()})
+
+	waitFor(t, 5*time.Second, "the restarted node to be alive again", func() bool {
+		a, _ := lists[0].Member(victim)
+		b, _ := lists[1].Member(victim)
+		return a.State == Alive && b.State == Alive && a.Incarnation > dead.Incarnation
+	})
+
+	list.Stop()
+	restarted.Close()
+	for i := 0; i < 2; i++ {
+		lists[i].Stop()
+		nodes[i].Close()
+	}
+}
+
+func TestUpdatesRideOnGossip(t *testing.T) {
+	net := network.NewMockNetwork()
+	gossipers := make([]*gossip.GossipNode, 2)
+	lists := make([]*Memberlist, 2)
+	for i := range gossipers {
+		n, err := node.NewNode(net, network.Address{IP: "127.0.0.1", Port: 9000 + i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the lists are not started, so no SWIM message carries the update
+		lists[i] = New(n, testConfig())
+		gn, err := gossip.NewGossipNodeFromNode(n, i, gossip.DefaultGossipConfig(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gn.SetPiggybacker(lists[i])
+		gossipers[i] = gn
+	}
+	gossipers[0].AddPeer(gossipers[1].Address())
+	for _, gn := range gossipers {
+		gn.Start()
+		defer gn.Close()
+	}
+
+	joined := network.Address{IP: "127.0.0.1", Port: 9100}
+	id, _ := node.NewRandomNodeID()
+	lists[0].apply(update{Addr: joined, ID: id, State: Alive})
+
+	gossipers[0].Gossip("hello")
+	waitFor(t, time.Second, "the update to arrive with the rumor", func() bool {
+		member, exists := lists[1].Member(joined)
+		return exists && member.State == Alive
+	})
+}